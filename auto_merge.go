@@ -0,0 +1,100 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"context"
+	"log"
+	"time"
+)
+
+// 后台定时检查可回收的数据量，达到阈值并且在允许的时间窗口内时自动执行 merge
+func (db *DB) autoMerge() {
+	defer db.bgTasks.Done()
+	ticker := time.NewTicker(db.options.AutoMerge.CheckInterval)
+	defer ticker.Stop()
+	// 数据库关闭时取消正在执行的 merge
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-db.closeCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		select {
+		case <-db.closeCh:
+			return
+		case now := <-ticker.C:
+			ok, err := db.shouldAutoMerge(now)
+			if err != nil {
+				log.Printf("auto merge check failed: %v", err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			err = db.merge(mergeConfig{
+				ctx:        ctx,
+				checkRatio: false,
+				limiter:    utils.NewRateLimiter(db.options.AutoMerge.BytesPerSecond),
+			})
+			if err != nil && err != ErrMergeIsProgress && ctx.Err() == nil {
+				log.Printf("auto merge failed: %v", err)
+			}
+		}
+	}
+}
+
+func (db *DB) shouldAutoMerge(now time.Time) (bool, error) {
+	opts := db.options.AutoMerge
+	if len(opts.Windows) > 0 {
+		var inWindow bool
+		for _, window := range opts.Windows {
+			if window.contains(now) {
+				inWindow = true
+				break
+			}
+		}
+		if !inWindow {
+			return false, nil
+		}
+	}
+
+	ratio := opts.MergeRatio
+	if ratio == 0 {
+		ratio = db.options.DataFileMergeRatio
+	}
+	// 上一次 merge 已经覆盖的可回收数据不再重复计算
+	db.mu.RLock()
+	reclaimSize := db.reclaimSize - db.mergedReclaimSize
+	db.mu.RUnlock()
+	if reclaimSize <= 0 {
+		return false, nil
+	}
+	totalSize, err := utils.DirSize(db.options.DirPath)
+	if err != nil {
+		return false, err
+	}
+	return float32(reclaimSize)/float32(totalSize) >= ratio, nil
+}
+
+func (w MergeWindow) contains(t time.Time) bool {
+	year, month, day := t.Date()
+	offset := t.Sub(time.Date(year, month, day, 0, 0, 0, 0, t.Location()))
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// 通知所有后台任务退出并等待其结束
+func (db *DB) stopBackgroundTasks() {
+	select {
+	case <-db.closeCh:
+	default:
+		close(db.closeCh)
+	}
+	db.bgTasks.Wait()
+}
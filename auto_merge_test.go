@@ -0,0 +1,102 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_AutoMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge")
+	opts.DirPath = dir
+	opts.AutoMerge.Enable = true
+	opts.AutoMerge.CheckInterval = time.Millisecond * 50
+	opts.AutoMerge.MergeRatio = 0.3
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		_ = os.RemoveAll(db.getMergePath())
+	}()
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 8000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 等待后台完成 merge
+	mergeFinFileName := filepath.Join(db.getMergePath(), data.MergeFinishedFileName)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(mergeFinFileName)
+		return err == nil
+	}, time.Second*5, time.Millisecond*50)
+
+	// 重启之后 merge 的结果生效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(db2.ListKeys()))
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_AutoMerge_Close(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge-close")
+	opts.DirPath = dir
+	opts.AutoMerge.Enable = true
+	opts.AutoMerge.CheckInterval = time.Millisecond * 50
+	opts.AutoMerge.MergeRatio = 0.3
+	// 限速之后 merge 需要很长时间
+	opts.AutoMerge.BytesPerSecond = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 8000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+		return db.isMerging
+	}, time.Second*5, time.Millisecond*10)
+
+	// 关闭时取消正在执行的 merge，不需要等待 merge 完成
+	now := time.Now()
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.True(t, time.Since(now) < time.Second)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestMergeWindow_contains(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	w1 := MergeWindow{Start: time.Hour * 2, End: time.Hour * 4}
+	assert.True(t, w1.contains(day.Add(time.Hour*3)))
+	assert.False(t, w1.contains(day.Add(time.Hour*5)))
+
+	// 跨越零点的时间窗口
+	w2 := MergeWindow{Start: time.Hour * 22, End: time.Hour * 6}
+	assert.True(t, w2.contains(day.Add(time.Hour*23)))
+	assert.True(t, w2.contains(day.Add(time.Hour*1)))
+	assert.False(t, w2.contains(day.Add(time.Hour*12)))
+}
@@ -2,6 +2,7 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/index"
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
@@ -19,9 +20,6 @@ type WriteBatch struct {
 }
 
 func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
-	if db.options.IndexType == BPlusTree && !db.seqNoFileExists && !db.isInitial {
-		panic("can  not use write batch, seq no file not exists")
-	}
 	return &WriteBatch{
 		options:       opts,
 		mu:            new(sync.Mutex),
@@ -69,57 +67,77 @@ func (wb *WriteBatch) Commit() error {
 	if uint(len(wb.pendingWrites)) > wb.options.MaxBatchNum {
 		return ErrExceedMaxBatchNum
 	}
-	// 当前最新的事物序列号
-	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
-
-	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.AppendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
-		})
-		if err != nil {
-			return err
-		}
-		positions[string(record.Key)] = logRecordPos
-	}
-	finishedRecord := &data.LogRecord{
-		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
-		Type: data.LogRecordTxnFinished,
-	}
-	if _, err := wb.db.AppendLogRecord(finishedRecord); err != nil {
+	if err := wb.db.commitRecords(wb.pendingWrites, wb.options.SyncWrites, nil); err != nil {
 		return err
 	}
 
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
-			return nil
-		}
-	}
-	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
-		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
-		}
-		if record.Type == data.LogRecordDelete {
-			oldPos, _ = wb.db.index.Delete(record.Key)
-		}
-		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size)
-		}
-	}
-
 	// 清空数据结构
 
 	wb.pendingWrites = make(map[string]*data.LogRecord)
 	return nil
 }
 
+// 以事务的方式写入一批数据并更新内存索引，和其他并发的写入一起组提交
+// check 不为空时在写入之前持有 db.mu 调用，返回错误时放弃写入
+func (db *DB) commitRecords(records map[string]*data.LogRecord, sync bool,
+	check func(written map[string]struct{}) error) error {
+	keys := make([][]byte, 0, len(records))
+	for _, record := range records {
+		keys = append(keys, record.Key)
+	}
+	return db.commit(&commitRequest{
+		prepare: func(written map[string]struct{}) ([]*data.LogRecord, error) {
+			if check != nil {
+				if err := check(written); err != nil {
+					return nil, err
+				}
+			}
+			if len(keys) == 0 {
+				return nil, nil
+			}
+			// 当前最新的事物序列号
+			seqNo := atomic.AddUint64(&db.seqNo, 1)
+			logRecords := make([]*data.LogRecord, 0, len(keys)+1)
+			for _, key := range keys {
+				record := records[string(key)]
+				logRecords = append(logRecords, &data.LogRecord{
+					Key:    logRecordKeyWithSeq(record.Key, seqNo),
+					Value:  record.Value,
+					Type:   record.Type,
+					Expire: record.Expire,
+				})
+			}
+			logRecords = append(logRecords, &data.LogRecord{
+				Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
+				Type: data.LogRecordTxnFinished,
+			})
+			return logRecords, nil
+		},
+		apply: func(positions []*data.LogRecordPos) []*data.LogRecordPos {
+			// 一次批量更新内存索引
+			var deadPositions []*data.LogRecordPos
+			ops := make([]index.BatchOp, 0, len(keys))
+			for i, key := range keys {
+				record, pos := records[string(key)], positions[i]
+				switch record.Type {
+				case data.LogRecordNormal:
+					ops = append(ops, index.BatchOp{Key: record.Key, Pos: pos})
+				case data.LogRecordDelete:
+					ops = append(ops, index.BatchOp{Key: record.Key})
+					deadPositions = append(deadPositions, pos)
+				}
+			}
+			for _, oldPos := range db.index.ApplyBatch(ops) {
+				if oldPos != nil {
+					deadPositions = append(deadPositions, oldPos)
+				}
+			}
+			return deadPositions
+		},
+		sync: sync,
+	})
+}
+
 func logRecordKeyWithSeq(key []byte, seqNo uint64) []byte {
 	seq := make([]byte, binary.MaxVarintLen64)
 	n := binary.PutUvarint(seq[:], seqNo)
@@ -0,0 +1,321 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// 检查点文件的格式和 hint 索引文件相同，每条记录是一个 key 和它的位置
+// 最后一条记录是完成标识，没有完成标识的检查点文件不会被使用
+const indexCheckpointFinKey = "checkpoint.fin"
+
+var errInvalidIndexCheckpoint = errors.New("invalid index checkpoint")
+
+// 检查点覆盖到的位置，在这之前写入的数据都已经保存在检查点中
+type indexCheckpoint struct {
+	fid         uint32
+	offset      int64
+	seqNo       uint64
+	reclaimSize int64
+	keyNum      int64
+	deadSizes   map[uint32]int64 // 每个数据文件中可以回收的数据量
+	// 每个数据文件中 value 压缩前和实际占用的大小
+	valueSizes           map[uint32]int64
+	compressedValueSizes map[uint32]int64
+}
+
+func (cp *indexCheckpoint) encode() []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*5+
+		len(cp.deadSizes)*(binary.MaxVarintLen32+binary.MaxVarintLen64))
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(cp.fid))
+	index += binary.PutVarint(buf[index:], cp.offset)
+	index += binary.PutUvarint(buf[index:], cp.seqNo)
+	index += binary.PutVarint(buf[index:], cp.reclaimSize)
+	index += binary.PutVarint(buf[index:], cp.keyNum)
+	index += binary.PutUvarint(buf[index:], uint64(len(cp.deadSizes)))
+	for fid, size := range cp.deadSizes {
+		index += binary.PutUvarint(buf[index:], uint64(fid))
+		index += binary.PutVarint(buf[index:], size)
+	}
+	buf = appendFileSizes(buf[:index], cp.valueSizes)
+	return appendFileSizes(buf, cp.compressedValueSizes)
+}
+
+func decodeIndexCheckpoint(buf []byte) (*indexCheckpoint, error) {
+	var index = 0
+	readUvarint := func() (uint64, error) {
+		v, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return 0, errInvalidIndexCheckpoint
+		}
+		index += n
+		return v, nil
+	}
+	readVarint := func() (int64, error) {
+		v, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return 0, errInvalidIndexCheckpoint
+		}
+		index += n
+		return v, nil
+	}
+	cp := &indexCheckpoint{}
+	fid, err := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	cp.fid = uint32(fid)
+	if cp.offset, err = readVarint(); err != nil {
+		return nil, err
+	}
+	if cp.seqNo, err = readUvarint(); err != nil {
+		return nil, err
+	}
+	if cp.reclaimSize, err = readVarint(); err != nil {
+		return nil, err
+	}
+	if cp.keyNum, err = readVarint(); err != nil {
+		return nil, err
+	}
+	// 旧版本的检查点中没有每个文件的统计
+	if index == len(buf) {
+		return cp, nil
+	}
+	n, err := readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	cp.deadSizes = make(map[uint32]int64, n)
+	for i := uint64(0); i < n; i++ {
+		fid, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		if cp.deadSizes[uint32(fid)], err = readVarint(); err != nil {
+			return nil, err
+		}
+	}
+	// 旧版本的检查点中没有 value 的大小
+	if index == len(buf) {
+		return cp, nil
+	}
+	rest := buf[index:]
+	if cp.valueSizes, rest, err = decodeFileSizes(rest); err != nil {
+		return nil, errInvalidIndexCheckpoint
+	}
+	if cp.compressedValueSizes, _, err = decodeFileSizes(rest); err != nil {
+		return nil, errInvalidIndexCheckpoint
+	}
+	return cp, nil
+}
+
+// CheckpointIndex 把当前的内存索引保存到检查点文件中，重启时加载检查点之后只需要重放检查点之后写入的数据
+// B+ 树索引本身就保存在磁盘上，不需要检查点
+func (db *DB) CheckpointIndex() error {
+	if db.options.IndexType == BPlusTree {
+		return nil
+	}
+	db.checkpointLock.Lock()
+	defer db.checkpointLock.Unlock()
+
+	// 检查点的位置之前写入的数据需要都已经更新到索引中
+	resume := db.pauseCommit()
+	db.mu.Lock()
+	unlock := func() {
+		db.mu.Unlock()
+		resume()
+	}
+	if db.activeFile == nil {
+		unlock()
+		return nil
+	}
+	// 检查点中的位置指向的数据必须已经持久化
+	if err := db.activeFile.Sync(); err != nil {
+		unlock()
+		return err
+	}
+	cp := &indexCheckpoint{
+		fid:         db.activeFile.FileId,
+		offset:      db.activeFile.WriteOff,
+		seqNo:       atomic.LoadUint64(&db.seqNo),
+		reclaimSize: db.reclaimSize,
+		deadSizes:   maps.Clone(db.deadSizes),
+		// 检查点所在的文件启动时会重新读取，其他文件的大小不会再变
+		valueSizes:           maps.Clone(db.valueSizes),
+		compressedValueSizes: maps.Clone(db.compressedValueSizes),
+	}
+	indexer := db.index.Clone()
+	unlock()
+	defer func() { _ = indexer.Close() }()
+
+	return db.writeIndexCheckpoint(cp, indexer)
+}
+
+// 先写到临时文件再重命名，检查点文件存在时一定是完整的
+func (db *DB) writeIndexCheckpoint(cp *indexCheckpoint, indexer index.Indexer) error {
+	fileName := filepath.Join(db.options.DirPath, data.IndexCheckpointFileName)
+	tmpFileName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	if err := db.writeIndexCheckpointRecords(file, cp, indexer); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmpFileName)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+func (db *DB) writeIndexCheckpointRecords(file *os.File, cp *indexCheckpoint, indexer index.Indexer) error {
+	writer := bufio.NewWriter(file)
+	write := func(logRecord *data.LogRecord) error {
+		encRecord, _, err := data.EncodeLogRecordWithCipher(logRecord, db.cipher)
+		if err != nil {
+			return err
+		}
+		_, err = writer.Write(encRecord)
+		return err
+	}
+
+	iterator := indexer.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		// 数据库关闭时放弃本次检查点
+		if cp.keyNum%1024 == 0 {
+			select {
+			case <-db.closeCh:
+				return ErrDatabaseClosed
+			default:
+			}
+		}
+		err := write(&data.LogRecord{
+			Key:   iterator.Key(),
+			Value: data.EncodeLogRecordPos(iterator.Value()),
+		})
+		if err != nil {
+			return err
+		}
+		cp.keyNum++
+	}
+	err := write(&data.LogRecord{
+		Key:   []byte(indexCheckpointFinKey),
+		Value: cp.encode(),
+		Type:  data.LogRecordTxnFinished,
+	})
+	if err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// 从检查点文件中加载索引，检查点不存在或者不可用时返回 nil，之后从数据文件中加载全部的索引
+func (db *DB) loadIndexCheckpoint() (*indexCheckpoint, error) {
+	fileName := filepath.Join(db.options.DirPath, data.IndexCheckpointFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil, nil
+	}
+	cpFile, err := data.OpenIndexCheckpointFile(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer cpFile.Close()
+	cpFile.Cipher = db.cipher
+
+	var cp *indexCheckpoint
+	var keys [][]byte
+	var positions []*data.LogRecordPos
+	var offset int64 = 0
+	for cp == nil {
+		logRecord, size, err := cpFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("failed to read index checkpoint, load from data files: %v", err)
+			return nil, nil
+		}
+		if logRecord.Type == data.LogRecordTxnFinished && string(logRecord.Key) == indexCheckpointFinKey {
+			if cp, err = decodeIndexCheckpoint(logRecord.Value); err != nil {
+				log.Printf("failed to read index checkpoint, load from data files: %v", err)
+				return nil, nil
+			}
+			break
+		}
+		keys = append(keys, logRecord.Key)
+		positions = append(positions, data.DecodeLogRecordPos(logRecord.Value))
+		offset += size
+	}
+	if cp == nil || cp.keyNum != int64(len(keys)) {
+		log.Printf("index checkpoint is incomplete, load from data files")
+		return nil, nil
+	}
+	// 检查点覆盖到的数据文件已经不存在或者被截断，检查点中的位置不再可信
+	if ok, err := db.containsDataFileOffset(cp.fid, cp.offset); err != nil || !ok {
+		if err == nil {
+			log.Printf("data file %d does not contain offset %d of index checkpoint, load from data files", cp.fid, cp.offset)
+		}
+		return nil, err
+	}
+
+	ops := make([]index.BatchOp, 0, len(keys))
+	for i, key := range keys {
+		// 检查点之后才过期的数据不再加载
+		if positions[i].IsExpired() {
+			db.addDeadSize(positions[i])
+		} else {
+			ops = append(ops, index.BatchOp{Key: key, Pos: positions[i]})
+		}
+	}
+	db.index.ApplyBatch(ops)
+	db.seqNo = cp.seqNo
+	db.reclaimSize += cp.reclaimSize
+	db.restoreDeadSizes(cp.deadSizes)
+	db.restoreValueSizes(cp.valueSizes, cp.compressedValueSizes)
+	return cp, nil
+}
+
+// 后台定时保存索引检查点
+func (db *DB) autoCheckpointIndex() {
+	defer db.bgTasks.Done()
+	ticker := time.NewTicker(db.options.IndexCheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.closeCh:
+			return
+		case <-ticker.C:
+			err := db.CheckpointIndex()
+			if err == nil {
+				continue
+			}
+			if err == ErrDatabaseClosed {
+				return
+			}
+			// 数据目录已经被删除，之后的检查点都会失败
+			if _, statErr := os.Stat(db.options.DirPath); os.IsNotExist(statErr) {
+				log.Printf("index checkpoint stopped, data directory %s is gone", db.options.DirPath)
+				return
+			}
+			log.Printf("index checkpoint failed: %v", err)
+		}
+	}
+}
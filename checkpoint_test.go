@@ -0,0 +1,171 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_CheckpointIndex(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, ART} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint")
+		opts.DirPath = dir
+		opts.DataFileSize = 32 * 1024
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		expected := make(map[string][]byte)
+		put := func(i int) {
+			value := utils.RandomValue(32)
+			assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+			expected[string(utils.GetTestKey(i))] = value
+		}
+		for i := 0; i < 1000; i++ {
+			put(i)
+		}
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Delete(utils.GetTestKey(0)))
+		assert.Nil(t, wb.Commit())
+		delete(expected, string(utils.GetTestKey(0)))
+		err = db.CheckpointIndex()
+		assert.Nil(t, err)
+
+		// 检查点之后的写入在重启时重放
+		for i := 900; i < 1100; i++ {
+			put(i)
+		}
+		assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+		delete(expected, string(utils.GetTestKey(1)))
+		wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Delete(utils.GetTestKey(2)))
+		assert.Nil(t, wb.Put(utils.GetTestKey(3), []byte("batch value")))
+		assert.Nil(t, wb.Commit())
+		delete(expected, string(utils.GetTestKey(2)))
+		expected[string(utils.GetTestKey(3))] = []byte("batch value")
+		waitDataHintFile(t, dir, 0)
+		assert.Nil(t, db.Close())
+
+		report, err := Verify(dir)
+		assert.Nil(t, err)
+		assert.True(t, report.OK(), report.Problems)
+
+		// 检查点之前写满的数据文件不再读取，也不会为它重新生成 hint 文件
+		assert.Nil(t, os.Remove(data.GetDataHintFileName(dir, 0)))
+
+		check := func() {
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			assert.Equal(t, len(expected), len(db.ListKeys()))
+			for key, value := range expected {
+				val, err := db.Get([]byte(key))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+			// 事务序列号在重启之后继续递增
+			wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+			assert.Nil(t, wb.Put(utils.GetTestKey(4), []byte("value")))
+			assert.Nil(t, wb.Commit())
+			expected[string(utils.GetTestKey(4))] = []byte("value")
+			assert.Nil(t, db.Close())
+		}
+		check()
+		_, err = os.Stat(data.GetDataHintFileName(dir, 0))
+		assert.True(t, os.IsNotExist(err))
+
+		// 检查点文件不完整时从数据文件中加载全部的索引
+		checkpointFileName := filepath.Join(dir, data.IndexCheckpointFileName)
+		stat, err := os.Stat(checkpointFileName)
+		assert.Nil(t, err)
+		assert.Nil(t, os.Truncate(checkpointFileName, stat.Size()/2))
+		check()
+
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestDB_CheckpointIndex_Auto(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-auto")
+	opts.DirPath = dir
+	opts.IndexCheckpointInterval = 10 * time.Millisecond
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	checkpointFileName := filepath.Join(dir, data.IndexCheckpointFileName)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(checkpointFileName)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// merge 之后检查点中的位置失效，重启时删除检查点文件
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	opts.IndexCheckpointInterval = 0
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(checkpointFileName)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 50, len(db.ListKeys()))
+	for i := 50; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	opts.IndexCheckpointInterval = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_CheckpointIndex_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-checkpoint-merge")
+	opts.DirPath = dir
+	opts.IndexCheckpointInterval = 10 * time.Millisecond
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	// 让 merge 持续一段时间，期间 merge 使用的临时数据库不能保存检查点
+	mergeOpts := DefaultMergeOptions
+	mergeOpts.Progress = func(progress MergeProgress) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Nil(t, db.MergeWithContext(context.Background(), mergeOpts))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, len(db.ListKeys()))
+	for i := 50; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
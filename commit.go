@@ -0,0 +1,151 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"log"
+	"sync"
+)
+
+// commitRequest 一次写入请求，由提交队列的 leader 和其他请求一起写入
+type commitRequest struct {
+	// 持有 db.mu 时调用，返回需要写入的记录，返回空表示不需要写入
+	// 同一组中排在前面的请求还没有更新内存索引，written 中是这些请求写入的 key
+	prepare func(written map[string]struct{}) ([]*data.LogRecord, error)
+	// 记录写入之后用写入的位置更新内存索引，返回被覆盖或者删除、可以回收的数据的位置
+	// 调用时不持有 db.mu，读取只需要等待索引自己的锁，同一时间只有一个 leader 在更新索引
+	apply func(positions []*data.LogRecordPos) []*data.LogRecordPos
+	sync  bool // 是否需要持久化之后才返回
+	err   error
+	done  bool
+}
+
+// commitQueue 组提交队列，同一时间只有一个 leader 把排队的请求合并写入，其他的请求等待 leader 完成
+type commitQueue struct {
+	mu      *sync.Mutex
+	cond    *sync.Cond
+	pending []*commitRequest
+	leading bool // 是否有 leader 正在写入
+}
+
+func newCommitQueue() *commitQueue {
+	mu := new(sync.Mutex)
+	return &commitQueue{
+		mu:   mu,
+		cond: sync.NewCond(mu),
+	}
+}
+
+// 把请求加入提交队列，等待请求被写入之后返回
+// 没有 leader 时当前请求成为 leader，写入队列中所有的请求
+func (db *DB) commit(req *commitRequest) error {
+	q := db.commitQueue
+	q.mu.Lock()
+	q.pending = append(q.pending, req)
+	for !req.done {
+		if q.leading {
+			q.cond.Wait()
+			continue
+		}
+		q.leading = true
+		group := q.pending
+		q.pending = nil
+		q.mu.Unlock()
+
+		db.commitGroup(group)
+
+		q.mu.Lock()
+		for _, r := range group {
+			r.done = true
+		}
+		q.leading = false
+		q.cond.Broadcast()
+	}
+	q.mu.Unlock()
+	return req.err
+}
+
+// 等待正在进行的组提交更新完索引，之后新的组提交在调用返回的函数之前不会开始
+// 组提交释放 db.mu 之后才更新索引，需要活跃文件的写入位置和索引一致时调用，需要在获取 db.mu 之前调用
+func (db *DB) pauseCommit() func() {
+	q := db.commitQueue
+	q.mu.Lock()
+	for q.leading {
+		q.cond.Wait()
+	}
+	q.leading = true
+	q.mu.Unlock()
+	return func() {
+		q.mu.Lock()
+		q.leading = false
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	}
+}
+
+// 用一次写入和最多一次持久化提交一组请求
+// 只在写入时持有 db.mu，更新索引时不持有，索引的分片可以和读取并发
+func (db *DB) commitGroup(group []*commitRequest) {
+	positions, counts := db.appendCommitGroup(group)
+	if len(positions) == 0 {
+		return
+	}
+	var deadPositions []*data.LogRecordPos
+	for i, req := range group {
+		if counts[i] == 0 {
+			continue
+		}
+		deadPositions = append(deadPositions, req.apply(positions[:counts[i]])...)
+		positions = positions[counts[i]:]
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, pos := range deadPositions {
+		db.addDeadSize(pos)
+	}
+	// 位置没有更新只会让重启时多重放一些数据，不影响这次写入
+	if err := db.saveAppliedPositionOnRotate(); err != nil {
+		log.Printf("failed to save applied position of index: %v", err)
+	}
+}
+
+// 持有 db.mu 把一组请求的记录写入活跃文件，返回写入的位置和每个请求的记录数量
+func (db *DB) appendCommitGroup(group []*commitRequest) ([]*data.LogRecordPos, []int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var records []*data.LogRecord
+	var sync bool
+	counts := make([]int, len(group))
+	written := make(map[string]struct{})
+	for i, req := range group {
+		reqRecords, err := req.prepare(written)
+		if err != nil {
+			req.err = err
+			continue
+		}
+		for _, record := range reqRecords {
+			if record.Type == data.LogRecordNormal || record.Type == data.LogRecordDelete {
+				key, _ := parseLogRecordKey(record.Key)
+				written[string(key)] = struct{}{}
+			}
+		}
+		counts[i] = len(reqRecords)
+		records = append(records, reqRecords...)
+		sync = sync || (req.sync && len(reqRecords) > 0)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	positions, err := db.appendLogRecords(records, sync)
+	if err != nil {
+		for i, req := range group {
+			if counts[i] > 0 {
+				req.err = err
+			}
+		}
+		return nil, nil
+	}
+	return positions, counts
+}
@@ -0,0 +1,204 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestDB_ConcurrentWrites(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-concurrent-writes")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexShards = 4
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 并发的写入通过组提交合并写入，数据文件写满时切换新的活跃文件
+	value := utils.RandomValue(64)
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 2000; i += 8 {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+				if i%4 == 0 {
+					assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	check := func(db *DB) {
+		assert.Equal(t, 1500, len(db.ListKeys()))
+		for i := 0; i < 2000; i++ {
+			_, err := db.Get(utils.GetTestKey(i))
+			if i%4 == 0 {
+				assert.Equal(t, ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+			}
+		}
+	}
+	check(db)
+	assert.True(t, db.Stat().DataFileNum > 1)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	opts.IndexType = BPlusTree
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_GroupCommit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit")
+	opts.DirPath = dir
+	opts.SyncWrite = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	err = db.Put([]byte("read-key"), []byte("value"))
+	assert.Nil(t, err)
+
+	txn := db.Begin()
+	_, err = txn.Get([]byte("read-key"))
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put([]byte("txn-key"), []byte("value")))
+
+	// 假装已经有一个 leader 在写入，让后面的请求在队列中排队，之后被同一个 leader 一起提交
+	q := db.commitQueue
+	q.mu.Lock()
+	q.leading = true
+	q.mu.Unlock()
+	waitPending := func(n int) {
+		for {
+			q.mu.Lock()
+			pending := len(q.pending)
+			q.mu.Unlock()
+			if pending >= n {
+				return
+			}
+			runtime.Gosched()
+		}
+	}
+
+	wg := new(sync.WaitGroup)
+	errs := make([]error, 3)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		errs[0] = db.Put([]byte("read-key"), []byte("new value"))
+	}()
+	waitPending(1)
+	go func() {
+		defer wg.Done()
+		errs[1] = txn.Commit()
+	}()
+	waitPending(2)
+	go func() {
+		defer wg.Done()
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Put([]byte("batch-key"), []byte("value")))
+		assert.Nil(t, wb.Delete([]byte("read-key")))
+		errs[2] = wb.Commit()
+	}()
+	waitPending(3)
+
+	q.mu.Lock()
+	q.leading = false
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	wg.Wait()
+
+	// 事务读取的 key 被同一组中前面的请求修改了
+	assert.Nil(t, errs[0])
+	assert.Equal(t, ErrTxnConflict, errs[1])
+	assert.Nil(t, errs[2])
+
+	check := func(db *DB) {
+		_, err := db.Get([]byte("txn-key"))
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db.Get([]byte("read-key"))
+		assert.Equal(t, ErrKeyNotFound, err)
+		val, err := db.Get([]byte("batch-key"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), val)
+	}
+	check(db)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
+
+func TestDB_ConcurrentWrites_Checkpoint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-concurrent-checkpoint")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.IndexShards = 4
+	db, err := Open(opts)
+	defer func() { destroyDB(db) }()
+	assert.Nil(t, err)
+
+	// 组提交更新索引时不持有 db.mu，检查点、范围删除和压缩需要等待正在更新的索引
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 4000; i += 4 {
+				assert.Nil(t, db.Put(utils.GetTestKey(i%1000), bytes.Repeat([]byte(strconv.Itoa(i)), 16)))
+			}
+		}(w)
+	}
+	stop := make(chan struct{})
+	bgDone := make(chan struct{})
+	go func() {
+		defer close(bgDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			assert.Nil(t, db.CheckpointIndex())
+			assert.Nil(t, db.DeletePrefix([]byte("bitcask-go-key-00000000")))
+			err := db.Compact(DefaultCompactOptions)
+			assert.True(t, err == nil || err == ErrMergeIsProgress)
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-bgDone
+
+	values := make(map[string]string)
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		values[string(key)] = string(value)
+		return true
+	}))
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	reopened := make(map[string]string)
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+		reopened[string(key)] = string(value)
+		return true
+	}))
+	assert.Equal(t, values, reopened)
+}
@@ -0,0 +1,285 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// 压缩时从旧文件移动到新文件的一条记录
+type movedRecord struct {
+	key       []byte
+	oldOffset int64
+	newPos    *data.LogRecordPos
+}
+
+// 压缩之后的新文件中的记录和统计
+type compactedFile struct {
+	moved               []*movedRecord
+	size                int64
+	valueSize           int64 // value 压缩前的大小
+	compressedValueSize int64 // value 实际占用的大小
+}
+
+// Compact 在线压缩无效数据最多的几个旧数据文件，不需要重启，也只需要一个文件大小的额外空间
+// 每个文件压缩之后仍然使用原来的文件 id，替换文件和更新索引在持有 db.mu 时一起完成，
+// 仍然被快照引用的旧文件在快照释放之后关闭
+func (db *DB) Compact(opts CompactOptions) error {
+	if opts.MaxFiles < 0 || opts.MinGarbageRatio < 0 || opts.MinGarbageRatio > 1 {
+		return errors.New("invalid compact options")
+	}
+	db.mu.Lock()
+	if db.activeFile == nil {
+		db.mu.Unlock()
+		return nil
+	}
+	// 和 merge 一样需要根据索引判断记录是否有效，不能同时进行
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	db.isMerging = true
+	var minFileId = db.activeFile.FileId
+	for fid := range db.olderFiles {
+		minFileId = min(minFileId, fid)
+	}
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+
+	for _, dataFile := range db.pickCompactFiles(opts) {
+		// 最旧的文件之前没有数据，删除记录不需要保留
+		if err := db.compactDataFile(dataFile, dataFile.FileId == minFileId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 选出无效数据比例达到阈值的旧数据文件，无效数据多的排在前面
+func (db *DB) pickCompactFiles(opts CompactOptions) []*data.DataFile {
+	// 选出的文件中的数据都已经更新到索引中，之后的写入只会写到新的活跃文件
+	defer db.pauseCommit()()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var dataFiles []*data.DataFile
+	deadSizes := make(map[uint32]int64)
+	for _, stat := range db.dataFileStats() {
+		dataFile := db.olderFiles[stat.FileId]
+		if dataFile == nil || stat.DeadSize == 0 ||
+			float32(stat.DeadSize)/float32(stat.Size) < opts.MinGarbageRatio {
+			continue
+		}
+		dataFiles = append(dataFiles, dataFile)
+		deadSizes[stat.FileId] = stat.DeadSize
+	}
+	sort.SliceStable(dataFiles, func(i, j int) bool {
+		return deadSizes[dataFiles[i].FileId] > deadSizes[dataFiles[j].FileId]
+	})
+	if opts.MaxFiles > 0 && len(dataFiles) > opts.MaxFiles {
+		dataFiles = dataFiles[:opts.MaxFiles]
+	}
+	return dataFiles
+}
+
+// 把数据文件中有效的记录写到临时文件中，再替换原来的文件并更新索引
+func (db *DB) compactDataFile(dataFile *data.DataFile, isOldest bool) error {
+	fid := dataFile.FileId
+	tmpFileName := data.GetCompactDataFileName(db.options.DirPath, fid)
+	_ = os.Remove(tmpFileName)
+	compacted, err := db.writeCompactDataFile(dataFile, isOldest)
+	if err != nil {
+		_ = os.Remove(tmpFileName)
+		return err
+	}
+
+	// 等待正在写入的检查点完成再删除它，否则之后写完的检查点中仍然是这个文件中旧的位置
+	db.checkpointLock.Lock()
+	defer db.checkpointLock.Unlock()
+	// 组提交更新索引时不持有 db.mu，需要等它完成，否则新写入的位置可能被移动之后的旧位置覆盖
+	defer db.pauseCommit()()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	oldSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		_ = os.Remove(tmpFileName)
+		return err
+	}
+	if err := db.invalidateIndexFiles(fid); err != nil {
+		_ = os.Remove(tmpFileName)
+		return err
+	}
+	if err := os.Rename(tmpFileName, data.GetDataFileName(db.options.DirPath, fid)); err != nil {
+		_ = os.Remove(tmpFileName)
+		return err
+	}
+	newFile, err := db.openDataFile(db.options.DirPath, fid, db.options.IOType)
+	if err != nil {
+		return err
+	}
+	newFile.WriteOff = compacted.size
+
+	// 压缩的过程中被覆盖或者删除的 key 不需要更新
+	ops := make([]index.BatchOp, 0, len(compacted.moved))
+	var liveSize int64
+	for _, record := range compacted.moved {
+		pos := db.index.Get(record.key)
+		if pos != nil && pos.Fid == fid && pos.Offset == record.oldOffset {
+			ops = append(ops, index.BatchOp{Key: record.key, Pos: record.newPos})
+			liveSize += int64(record.newPos.Size)
+		}
+	}
+	db.index.ApplyBatch(ops)
+	// 新文件中保留的删除记录和压缩过程中被覆盖的记录仍然是无效的
+	db.reclaimSize = max(db.reclaimSize-(oldSize-compacted.size), 0)
+	db.deadSizes[fid] = compacted.size - liveSize
+	db.valueSizes[fid] = compacted.valueSize
+	db.compressedValueSizes[fid] = compacted.compressedValueSize
+
+	db.olderFiles[fid] = newFile
+	db.retireDataFile(dataFile, "")
+	db.writeDataHintFileAsync(newFile)
+	return nil
+}
+
+// 把有效的记录写到临时文件中，返回移动的记录和新文件的统计
+// 删除记录可能还需要覆盖更旧的文件中的数据，除了最旧的文件之外都会保留
+// 事务完成记录只在事务的其他记录都在这个文件中时才丢弃
+func (db *DB) writeCompactDataFile(dataFile *data.DataFile, isOldest bool) (*compactedFile, error) {
+	// 事务中的删除记录只有在事务完成之后才生效，先找到这个文件中完成的事务
+	finished := make(map[uint64]struct{})
+	// 一个事务的记录是连续写入的，只有文件开头的事务可能有记录在之前的文件中，它的完成记录需要保留
+	var spanSeqNo = NonTransitionSeqNo
+	_, err := db.scanDataFile(dataFile, false, func(logRecord *data.LogRecord, offset, size int64) error {
+		_, seqNo := parseLogRecordKey(logRecord.Key)
+		if offset == 0 {
+			spanSeqNo = seqNo
+		}
+		if logRecord.Type == data.LogRecordTxnFinished {
+			finished[seqNo] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	compactFile, err := data.OpenCompactDataFile(db.options.DirPath, dataFile.FileId)
+	if err != nil {
+		return nil, err
+	}
+	defer compactFile.Close()
+	compacted := &compactedFile{}
+	var buf []byte
+	write := func(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+		if logRecord.Type == data.LogRecordNormal {
+			logRecord.Compression = db.options.Compression
+		}
+		encRecord, size, err := data.EncodeLogRecordWithCipher(logRecord, db.cipher)
+		if err != nil {
+			return nil, err
+		}
+		pos := &data.LogRecordPos{Fid: dataFile.FileId, Offset: compacted.size, Size: uint32(size), Expire: logRecord.Expire}
+		buf = append(buf, encRecord...)
+		compacted.size += size
+		compacted.valueSize += int64(len(logRecord.Value))
+		compacted.compressedValueSize += data.EncodedValueSize(encRecord)
+		// 攒够一批之后再写入，减少系统调用
+		if len(buf) >= 4*1024*1024 {
+			if err := compactFile.Write(buf); err != nil {
+				return nil, err
+			}
+			buf = buf[:0]
+		}
+		return pos, nil
+	}
+
+	_, err = db.scanDataFile(dataFile, false, func(logRecord *data.LogRecord, offset, size int64) error {
+		select {
+		case <-db.closeCh:
+			return ErrDatabaseClosed
+		default:
+		}
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		_, txnFinished := finished[seqNo]
+		switch logRecord.Type {
+		case data.LogRecordNormal:
+			pos := db.index.Get(realKey)
+			if pos == nil || pos.Fid != dataFile.FileId || pos.Offset != offset || pos.IsExpired() {
+				return nil
+			}
+			// 有效的记录一定已经生效，清除事务标记
+			logRecord.Key = logRecordKeyWithSeq(realKey, NonTransitionSeqNo)
+			newPos, err := write(logRecord)
+			if err != nil {
+				return err
+			}
+			compacted.moved = append(compacted.moved, &movedRecord{key: realKey, oldOffset: offset, newPos: newPos})
+		case data.LogRecordDelete, data.LogRecordRangeDelete:
+			if isOldest {
+				return nil
+			}
+			// 事务跨越了多个文件时保留事务标记，等待之后的文件中的完成记录
+			if seqNo != NonTransitionSeqNo && txnFinished {
+				logRecord.Key = logRecordKeyWithSeq(realKey, NonTransitionSeqNo)
+			}
+			if _, err := write(logRecord); err != nil {
+				return err
+			}
+		case data.LogRecordTxnFinished:
+			if seqNo == NonTransitionSeqNo || seqNo != spanSeqNo {
+				return nil
+			}
+			if _, err := write(logRecord); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) > 0 {
+		if err := compactFile.Write(buf); err != nil {
+			return nil, err
+		}
+	}
+	if err := compactFile.Sync(); err != nil {
+		return nil, err
+	}
+	return compacted, nil
+}
+
+// 数据文件被替换之后，其中的记录的位置都变了，检查点、B+ 树索引中记录的位置和 hint 文件都不能再使用，需要持有 db.mu
+func (db *DB) invalidateIndexFiles(fid uint32) error {
+	fileNames := []string{
+		filepath.Join(db.options.DirPath, data.IndexCheckpointFileName),
+		data.GetDataHintFileName(db.options.DirPath, fid),
+	}
+	// merge 生成的 hint 索引文件中可能有这个文件中的位置，之后启动时从数据文件中加载
+	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); err == nil {
+		nonMergeFileId, err := db.getNonMergeFileId(db.options.DirPath)
+		if err != nil {
+			return err
+		}
+		if fid < nonMergeFileId {
+			fileNames = append(fileNames, filepath.Join(db.options.DirPath, data.HintFileName), mergeFinFileName)
+		}
+	}
+	for _, fileName := range fileNames {
+		if err := os.RemoveAll(fileName); err != nil {
+			return err
+		}
+	}
+	if indexer, ok := db.index.(index.PersistentIndexer); ok {
+		return indexer.SetAppliedPosition(nil)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Compact(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, BPlusTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-compact")
+		opts.DirPath = dir
+		opts.DataFileSize = 32 * 1024
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		// 多次覆盖和删除，旧文件中大部分数据都是无效的
+		expected := make(map[string][]byte)
+		for round := 0; round < 4; round++ {
+			for i := 0; i < 300; i++ {
+				value := utils.RandomValue(32)
+				assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+				expected[string(utils.GetTestKey(i))] = value
+			}
+		}
+		for i := 0; i < 100; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			delete(expected, string(utils.GetTestKey(i)))
+		}
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		for i := 100; i < 120; i++ {
+			assert.Nil(t, wb.Delete(utils.GetTestKey(i)))
+			delete(expected, string(utils.GetTestKey(i)))
+		}
+		assert.Nil(t, wb.Commit())
+		// 再写入一些数据，上面的删除记录不在活跃文件中
+		for i := 1000; i < 1300; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+			expected[string(utils.GetTestKey(i))] = utils.GetTestKey(i)
+		}
+
+		snap := db.NewSnapshot()
+		// B+ 树索引的迭代器持有 bbolt 的只读事务，在同一个 goroutine 中更新索引可能死锁，使用快照的迭代器
+		var iter *Iterator
+		if indexType == BPlusTree {
+			iter = snap.NewIterator(DefaultIteratorOptions)
+		} else {
+			iter = db.NewIterator(DefaultIteratorOptions)
+		}
+		iter.Rewind()
+		beforeSize := db.Stat().DiskSize
+		fileNum := db.Stat().DataFileNum
+
+		err = db.Compact(CompactOptions{MinGarbageRatio: 2})
+		assert.NotNil(t, err)
+
+		err = db.Compact(CompactOptions{MaxFiles: 2, MinGarbageRatio: 0.5})
+		assert.Nil(t, err)
+		err = db.Compact(CompactOptions{MinGarbageRatio: 0.1})
+		assert.Nil(t, err)
+		db.bgTasks.Wait()
+		assert.True(t, db.Stat().DiskSize < beforeSize)
+		// 文件 id 保持不变
+		assert.Equal(t, fileNum, db.Stat().DataFileNum)
+
+		// 压缩之前创建的迭代器和快照仍然可以读到正确的数据
+		var count int
+		for ; iter.Valid(); iter.Next() {
+			val, err := iter.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, expected[string(iter.Key())], val)
+			count++
+		}
+		iter.Close()
+		assert.Equal(t, len(expected), count)
+		for key, value := range expected {
+			val, err := snap.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		snap.Release()
+
+		check := func() {
+			assert.Equal(t, len(expected), len(db.ListKeys()))
+			for key, value := range expected {
+				val, err := db.Get([]byte(key))
+				assert.Nil(t, err)
+				assert.Equal(t, value, val)
+			}
+			for i := 0; i < 120; i++ {
+				_, err := db.Get(utils.GetTestKey(i))
+				assert.Equal(t, ErrKeyNotFound, err)
+			}
+		}
+		check()
+		assert.Nil(t, db.Close())
+
+		report, err := Verify(dir)
+		assert.Nil(t, err)
+		assert.True(t, report.OK(), report.Problems)
+
+		// 留下的临时文件在启动时被删除
+		tmpFileName := data.GetCompactDataFileName(dir, 0)
+		assert.Nil(t, os.WriteFile(tmpFileName, []byte("garbage"), 0644))
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		check()
+		_, err = os.Stat(tmpFileName)
+		assert.True(t, os.IsNotExist(err))
+		destroyDB(db)
+	}
+}
+
+func TestDB_Compact_BatchAcrossFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compact-batch")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 批量写入的记录大部分在第一个文件中，完成记录在第二个文件中
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+		if db.activeFile.WriteOff >= 28*1024 {
+			break
+		}
+	}
+	expected := make(map[string][]byte)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1200; i++ {
+		value := utils.RandomValue(32)
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), value))
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, uint32(1), db.activeFile.FileId)
+
+	// 第二个文件中的其他数据都被覆盖，只有第二个文件需要压缩
+	for db.activeFile.FileId < 3 {
+		assert.Nil(t, db.Put(utils.GetTestKey(2000), utils.RandomValue(64)))
+	}
+	err = db.Compact(CompactOptions{MinGarbageRatio: 0.5})
+	assert.Nil(t, err)
+	db.bgTasks.Wait()
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for key, value := range expected {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
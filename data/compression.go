@@ -0,0 +1,83 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+var ErrUnsupportedCompression = errors.New("unsupported compression type")
+
+// CompressionType value 的压缩算法
+type CompressionType = byte
+
+const (
+	CompressionNone CompressionType = iota
+	CompressionSnappy
+	CompressionZstd
+	CompressionLZ4
+)
+
+// zstd 的编码器和解码器可以并发使用
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// IsValidCompression 判断是否是支持的压缩算法
+func IsValidCompression(typ CompressionType) bool {
+	return typ <= CompressionLZ4
+}
+
+// 压缩 value，压缩之后没有变小时返回 false，调用方应该保存原始数据
+func compressValue(typ CompressionType, value []byte) ([]byte, bool) {
+	var buf []byte
+	switch typ {
+	case CompressionSnappy:
+		buf = snappy.Encode(nil, value)
+	case CompressionZstd:
+		buf = zstdEncoder.EncodeAll(value, nil)
+	case CompressionLZ4:
+		// lz4 的块格式不包含原始长度，需要自己记录
+		buf = make([]byte, binary.MaxVarintLen64+lz4.CompressBlockBound(len(value)))
+		n := binary.PutUvarint(buf, uint64(len(value)))
+		size, err := lz4.CompressBlock(value, buf[n:], nil)
+		// 返回 0 表示数据无法压缩
+		if err != nil || size == 0 {
+			return nil, false
+		}
+		buf = buf[:n+size]
+	default:
+		return nil, false
+	}
+	if len(buf) >= len(value) {
+		return nil, false
+	}
+	return buf, true
+}
+
+func decompressValue(typ CompressionType, buf []byte) ([]byte, error) {
+	switch typ {
+	case CompressionNone:
+		return buf, nil
+	case CompressionSnappy:
+		return snappy.Decode(nil, buf)
+	case CompressionZstd:
+		return zstdDecoder.DecodeAll(buf, nil)
+	case CompressionLZ4:
+		size, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, lz4.ErrInvalidSourceShortBuffer
+		}
+		value := make([]byte, size)
+		m, err := lz4.UncompressBlock(buf[n:], value)
+		if err != nil {
+			return nil, err
+		}
+		return value[:m], nil
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+}
@@ -18,16 +18,26 @@ const SeqNoFileName = "seq-no"
 const DataFileNameSuffix = ".data"
 const HintFileName = "hint-index"
 
+// 每个数据文件对应的 hint 文件的后缀
+const DataHintFileNameSuffix = ".hint"
+
+// 在线压缩数据文件时，新的数据先写到带有这个后缀的临时文件中
+const CompactDataFileNameSuffix = ".compact"
+
 const MergeFinishedFileName = "merge-finished"
 
-// crc type keysize valuesize
-// 4  + 1 + 5 + 5
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 1 + 4
+// 内存索引的检查点文件
+const IndexCheckpointFileName = "index-checkpoint"
+
+// crc type keysize valuesize expire compression keyid
+// 4  + 1 + 5 + 5 + 10 + 1 + 5
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*3 + binary.MaxVarintLen64 + 1 + 4 + 1
 
 type DataFile struct {
 	FileId    uint32
 	WriteOff  int64
 	IoManager fio.IOManager
+	Cipher    *Cipher // 读写加密的记录时使用，为 nil 表示不加密
 }
 
 func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
@@ -40,11 +50,27 @@ func OpenHintFile(dirPath string) (*DataFile, error) {
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// OpenCompactDataFile 打开压缩数据文件时使用的临时文件
+func OpenCompactDataFile(dirPath string, fileId uint32) (*DataFile, error) {
+	return newDataFile(GetCompactDataFileName(dirPath, fileId), uint(fileId), fio.StandardFIO)
+}
+
+// OpenDataHintFile 打开数据文件对应的 hint 文件
+func OpenDataHintFile(dirPath string, fileId uint32) (*DataFile, error) {
+	return newDataFile(GetDataHintFileName(dirPath, fileId), uint(fileId), fio.StandardFIO)
+}
+
 func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
 	return newDataFile(fileName, 0, fio.StandardFIO)
 }
 
+// OpenIndexCheckpointFile 打开内存索引的检查点文件
+func OpenIndexCheckpointFile(dirPath string) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, IndexCheckpointFileName)
+	return newDataFile(fileName, 0, fio.StandardFIO)
+}
+
 func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
 	return newDataFile(fileName, 0, fio.StandardFIO)
@@ -53,6 +79,15 @@ func OpenSeqNoFile(dirPath string) (*DataFile, error) {
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
+
+func GetDataHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataHintFileNameSuffix)
+}
+
+func GetCompactDataFileName(dirPath string, fileId uint32) string {
+	return GetDataFileName(dirPath, fileId) + CompactDataFileNameSuffix
+}
+
 func newDataFile(filename string, fileId uint, ioType fio.FileIOType) (*DataFile, error) {
 	ioManager, err := fio.NewIOManager(filename, ioType)
 	if err != nil {
@@ -65,11 +100,17 @@ func newDataFile(filename string, fileId uint, ioType fio.FileIOType) (*DataFile
 	}, nil
 }
 
+// ReadLogRecord 读取 offset 处的一条记录
+// 读到文件末尾时返回 io.EOF，记录不完整时返回 io.ErrUnexpectedEOF，
+// 校验失败时返回 ErrInvalidCRC 以及记录头中声明的记录长度，解密失败时同样返回记录长度
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	fileSize, err := df.IoManager.Size()
 	if err != nil {
 		return nil, 0, err
 	}
+	if offset >= fileSize {
+		return nil, 0, io.EOF
+	}
 	// 如果读取最大的header长度 已经超过文件的长度，则仅仅需要读到文件末尾即可
 	var headerBytes int64 = maxLogRecordHeaderSize
 	if offset+maxLogRecordHeaderSize > fileSize {
@@ -81,31 +122,96 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	}
 	header, headerSize := DecoderLogRecorderHeader(headerBuf)
 	if header == nil {
-		return nil, 0, io.EOF
+		return nil, 0, io.ErrUnexpectedEOF
 	}
 	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
 		return nil, 0, io.EOF
 	}
-	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
-	var logRecordSize = headerSize + keySize + valueSize
+	keySize, payloadSize := int64(header.keySize), header.payloadSize()
+	var logRecordSize = headerSize + payloadSize
+	if offset+logRecordSize > fileSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
 
-	logRecord := &LogRecord{Type: header.recordType}
-	if keySize > 0 || valueSize > 0 {
-		kvBuf, err := df.readNByte(keySize+valueSize, offset+headerSize)
-		if err != nil {
+	var payload []byte
+	if payloadSize > 0 {
+		if payload, err = df.readNByte(payloadSize, offset+headerSize); err != nil {
 			return nil, 0, err
 		}
-		logRecord.Key = kvBuf[:keySize]
-		logRecord.Value = kvBuf[keySize:]
 	}
-	// 校验数据crc是否正确
-	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
+	// 校验数据crc是否正确，crc 校验的是保存的数据
+	crc := crc32.ChecksumIEEE(headerBuf[crc32.Size:headerSize])
+	crc = crc32.Update(crc, crc32.IEEETable, payload)
 	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+		return nil, logRecordSize, ErrInvalidCRC
+	}
+	// 校验通过之后再解密和解压
+	if header.encrypted {
+		if df.Cipher == nil {
+			return nil, logRecordSize, ErrNoKeyProvider
+		}
+		if payload, err = df.Cipher.open(header.keyId, payload, headerBuf[crc32.Size:headerSize]); err != nil {
+			return nil, logRecordSize, err
+		}
+	}
+
+	logRecord := &LogRecord{Type: header.recordType, Expire: header.expire}
+	if len(payload) > 0 {
+		logRecord.Key = payload[:keySize]
+		logRecord.Value = payload[keySize:]
+	}
+	if header.compression != CompressionNone {
+		value, err := decompressValue(header.compression, logRecord.Value)
+		if err != nil {
+			return nil, 0, err
+		}
+		logRecord.CompressedSize = int64(len(logRecord.Value))
+		logRecord.Value = value
+		logRecord.Compression = header.compression
 	}
 	return logRecord, logRecordSize, nil
 }
 
+// FindNextRecord 从 offset 开始向后查找下一条可以正确读取的记录，返回它的位置
+// 没有找到时返回 io.EOF，用于跳过文件中损坏的数据
+func (df *DataFile) FindNextRecord(offset int64) (int64, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	if offset >= fileSize {
+		return 0, io.EOF
+	}
+	// 一次读出剩余的数据，在内存中逐个位置尝试解析
+	buf, err := df.readNByte(fileSize-offset, offset)
+	if err != nil {
+		return 0, err
+	}
+	for i := range buf {
+		if isValidLogRecord(buf[i:]) {
+			return offset + int64(i), nil
+		}
+	}
+	return 0, io.EOF
+}
+
+// Truncate 将文件截断到指定的长度，用于丢弃末尾损坏的数据
+func (df *DataFile) Truncate(size int64) error {
+	if err := df.IoManager.Truncate(size); err != nil {
+		return err
+	}
+	df.WriteOff = size
+	return nil
+}
+
+// Preallocate 预先分配文件空间，只对支持预分配的 IO 类型生效
+func (df *DataFile) Preallocate(size int64) error {
+	if p, ok := df.IoManager.(fio.Preallocator); ok {
+		return p.Preallocate(size)
+	}
+	return nil
+}
+
 func (df *DataFile) Write(buf []byte) error {
 	n, err := df.IoManager.Write(buf)
 	if err != nil {
@@ -120,7 +226,10 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 		Key:   key,
 		Value: EncodeLogRecordPos(pos),
 	}
-	encRecord, _ := EncodeLogRecord(record)
+	encRecord, _, err := EncodeLogRecordWithCipher(record, df.Cipher)
+	if err != nil {
+		return err
+	}
 	return df.Write(encRecord)
 }
 func (df *DataFile) Sync() error {
@@ -131,6 +240,23 @@ func (df *DataFile) Close() error {
 	return df.IoManager.Close()
 }
 
+// 判断 buf 的开头是否是一条完整并且校验正确的记录
+func isValidLogRecord(buf []byte) bool {
+	header, headerSize := DecoderLogRecorderHeader(buf)
+	if header == nil {
+		return false
+	}
+	if header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
+		return false
+	}
+	size := headerSize + header.payloadSize()
+	if size > int64(len(buf)) {
+		return false
+	}
+	crc := crc32.ChecksumIEEE(buf[crc32.Size:size])
+	return crc == header.crc
+}
+
 func (df *DataFile) readNByte(n int64, offset int64) ([]byte, error) {
 	b := make([]byte, n)
 	_, err := df.IoManager.Read(b, offset)
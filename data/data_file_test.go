@@ -2,7 +2,9 @@ package data
 
 import (
 	"bitcask-go/fio"
+	"bytes"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
 	"testing"
 )
@@ -108,3 +110,111 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)
 }
+
+func TestDataFile_FindNextRecord(t *testing.T) {
+	dataFile, err := OpenDataFile(os.TempDir(), 555, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer func() {
+		_ = dataFile.Close()
+		_ = os.Remove(GetDataFileName(os.TempDir(), 555))
+	}()
+
+	rec, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask kv go")})
+	// 一段损坏的数据后面跟着一条完整的记录，最后是写了一半的记录
+	err = dataFile.Write([]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06})
+	assert.Nil(t, err)
+	err = dataFile.Write(rec)
+	assert.Nil(t, err)
+	err = dataFile.Write(rec[:size/2])
+	assert.Nil(t, err)
+
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.NotNil(t, err)
+	offset, err := dataFile.FindNextRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), offset)
+
+	_, _, err = dataFile.ReadLogRecord(6 + size)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = dataFile.FindNextRecord(7)
+	assert.Equal(t, io.EOF, err)
+
+	err = dataFile.Truncate(6 + size)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(6 + size)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDataFile_Encryption(t *testing.T) {
+	dataFile, err := OpenDataFile(os.TempDir(), 666, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer func() {
+		_ = dataFile.Close()
+		_ = os.Remove(GetDataFileName(os.TempDir(), 666))
+	}()
+	dataFile.Cipher = NewCipher(NewStaticKeyProvider([]byte("0123456789abcdef")))
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask kv go"), Expire: 100}
+	enc, size, err := EncodeLogRecordWithCipher(rec, dataFile.Cipher)
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(enc, rec.Value))
+	err = dataFile.Write(enc)
+	assert.Nil(t, err)
+
+	readRec, readSize, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+	assert.Equal(t, size, readSize)
+
+	// 密钥错误或者没有密钥时无法读取
+	dataFile.Cipher = NewCipher(NewStaticKeyProvider([]byte("fedcba9876543210")))
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrDecryptFailed, err)
+	dataFile.Cipher = nil
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrNoKeyProvider, err)
+}
+
+func TestDataHintFooter(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-hint-footer")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	assert.Nil(t, dataFile.Write(bytes.Repeat([]byte("a"), 10000)))
+
+	footer, err := NewDataHintFooter(dataFile, &DataHintFooter{ValueSize: 300, CompressedValueSize: 200})
+	assert.Nil(t, err)
+	enc, _, err := EncodeLogRecordWithCipher(footer, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, LogRecordHintFooter, enc[4]&logRecordTypeMask)
+	stat, ok, err := CheckDataHintFooter(footer, dataFile)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, &DataHintFooter{ValueSize: 300, CompressedValueSize: 200}, stat)
+
+	// 修改开头、末尾的数据或者追加数据之后不一致
+	for _, offset := range []int64{0, 9999} {
+		f, _ := os.OpenFile(GetDataFileName(dir, 0), os.O_WRONLY, 0644)
+		_, _ = f.WriteAt([]byte("b"), offset)
+		_ = f.Close()
+		_, ok, err = CheckDataHintFooter(footer, dataFile)
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		f, _ = os.OpenFile(GetDataFileName(dir, 0), os.O_WRONLY, 0644)
+		_, _ = f.WriteAt([]byte("a"), offset)
+		_ = f.Close()
+	}
+	_, ok, err = CheckDataHintFooter(footer, dataFile)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, dataFile.Write([]byte("a")))
+	_, ok, err = CheckDataHintFooter(footer, dataFile)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	_, _, err = CheckDataHintFooter(&LogRecord{Type: LogRecordHintFooter}, dataFile)
+	assert.Equal(t, ErrInvalidHintRecord, err)
+}
@@ -0,0 +1,140 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrDecryptFailed = errors.New("decrypt log record failed, the key may be wrong")
+	ErrNoKeyProvider = errors.New("log record is encrypted but no key provider is set")
+)
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+	// EncryptOverhead 加密之后增加的长度，nonce 和认证标签
+	EncryptOverhead  = nonceSize + tagSize
+	StaticEncryptKey = 1 // NewStaticKeyProvider 使用的密钥 id
+)
+
+// KeyProvider 提供 AES 密钥，密钥长度为 16、24 或 32 字节
+// 每条加密的记录都会保存写入时的密钥 id，轮换密钥之后旧的密钥仍然需要能够通过 Key 获取
+type KeyProvider interface {
+	// CurrentKey 返回写入新数据时使用的密钥及其 id
+	CurrentKey() (uint32, []byte, error)
+	// Key 根据 id 返回密钥，用于读取旧的数据
+	Key(keyId uint32) ([]byte, error)
+}
+
+type staticKeyProvider struct {
+	key []byte
+}
+
+// NewStaticKeyProvider 只使用一个固定密钥的 KeyProvider
+func NewStaticKeyProvider(key []byte) KeyProvider {
+	return &staticKeyProvider{key: key}
+}
+
+func (p *staticKeyProvider) CurrentKey() (uint32, []byte, error) {
+	return StaticEncryptKey, p.key, nil
+}
+
+func (p *staticKeyProvider) Key(keyId uint32) ([]byte, error) {
+	if keyId != StaticEncryptKey {
+		return nil, errors.New("encryption key not found")
+	}
+	return p.key, nil
+}
+
+// Cipher 使用 AES-GCM 加密记录中的 key 和 value
+type Cipher struct {
+	provider KeyProvider
+	mu       *sync.RWMutex
+	aeads    map[uint32]cipher.AEAD
+}
+
+func NewCipher(provider KeyProvider) *Cipher {
+	return &Cipher{
+		provider: provider,
+		mu:       new(sync.RWMutex),
+		aeads:    make(map[uint32]cipher.AEAD),
+	}
+}
+
+// 获取当前写入使用的密钥 id
+func (c *Cipher) currentKeyId() (uint32, error) {
+	keyId, key, err := c.provider.CurrentKey()
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.aeads[keyId]; !ok {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return 0, err
+		}
+		c.aeads[keyId] = aead
+	}
+	return keyId, nil
+}
+
+func (c *Cipher) aead(keyId uint32) (cipher.AEAD, error) {
+	c.mu.RLock()
+	aead, ok := c.aeads[keyId]
+	c.mu.RUnlock()
+	if ok {
+		return aead, nil
+	}
+	key, err := c.provider.Key(keyId)
+	if err != nil {
+		return nil, err
+	}
+	if aead, err = newAEAD(key); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.aeads[keyId] = aead
+	c.mu.Unlock()
+	return aead, nil
+}
+
+// 加密数据，返回 nonce 和密文拼接之后的结果，additionalData 同样会被认证
+func (c *Cipher) seal(keyId uint32, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := c.aead(keyId)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, nonceSize, nonceSize+len(plaintext)+tagSize)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return aead.Seal(buf, buf, plaintext, additionalData), nil
+}
+
+func (c *Cipher) open(keyId uint32, buf, additionalData []byte) ([]byte, error) {
+	aead, err := c.aead(keyId)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < EncryptOverhead {
+		return nil, ErrDecryptFailed
+	}
+	plaintext, err := aead.Open(nil, buf[:nonceSize], buf[nonceSize:], additionalData)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,121 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+var ErrInvalidHintRecord = errors.New("invalid hint record")
+
+// NewDataHintRecord 数据文件对应的 hint 文件中的记录
+// key、类型和过期时间与数据文件中的记录相同，value 是记录在数据文件中的偏移和长度，
+// 范围删除的记录在后面加上范围的上界
+func NewDataHintRecord(logRecord *LogRecord, offset, size int64) *LogRecord {
+	buf := make([]byte, binary.MaxVarintLen64*2, binary.MaxVarintLen64*2+len(logRecord.Value))
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(offset))
+	index += binary.PutUvarint(buf[index:], uint64(size))
+	value := buf[:index]
+	if logRecord.Type == LogRecordRangeDelete {
+		value = append(value, logRecord.Value...)
+	}
+	return &LogRecord{
+		Key:    logRecord.Key,
+		Value:  value,
+		Type:   logRecord.Type,
+		Expire: logRecord.Expire,
+	}
+}
+
+// ParseDataHintRecord 从 hint 记录中还原数据文件中的记录（不包含 value）和它的偏移、长度
+func ParseDataHintRecord(hintRecord *LogRecord) (*LogRecord, int64, int64, error) {
+	offset, n := binary.Uvarint(hintRecord.Value)
+	if n <= 0 {
+		return nil, 0, 0, ErrInvalidHintRecord
+	}
+	size, m := binary.Uvarint(hintRecord.Value[n:])
+	if m <= 0 {
+		return nil, 0, 0, ErrInvalidHintRecord
+	}
+	logRecord := &LogRecord{
+		Key:    hintRecord.Key,
+		Type:   hintRecord.Type,
+		Expire: hintRecord.Expire,
+	}
+	if hintRecord.Type == LogRecordRangeDelete {
+		logRecord.Value = hintRecord.Value[n+m:]
+	}
+	return logRecord, int64(offset), int64(size), nil
+}
+
+// hint 文件的结尾记录中校验的数据文件开头和末尾的数据量
+const hintFooterCheckSize = 4096
+
+// DataHintFooter hint 文件的结尾记录中保存的数据文件的统计
+type DataHintFooter struct {
+	ValueSize           int64 // 数据文件中 value 压缩前的大小
+	CompressedValueSize int64 // 数据文件中 value 实际占用的大小
+}
+
+// NewDataHintFooter hint 文件的结尾记录，保存数据文件当前的大小、开头和末尾数据的 CRC 以及 value 的大小
+// 读取 hint 文件时和数据文件比较，不依赖文件的修改时间
+func NewDataHintFooter(dataFile *DataFile, footer *DataHintFooter) (*LogRecord, error) {
+	size, crc, err := dataFileChecksum(dataFile)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.MaxVarintLen64*3+crc32.Size)
+	index := binary.PutUvarint(buf, uint64(size))
+	index += binary.PutUvarint(buf[index:], uint64(footer.ValueSize))
+	index += binary.PutUvarint(buf[index:], uint64(footer.CompressedValueSize))
+	binary.LittleEndian.PutUint32(buf[index:], crc)
+	return &LogRecord{Value: buf[:index+crc32.Size], Type: LogRecordHintFooter}, nil
+}
+
+// CheckDataHintFooter 判断 hint 文件的结尾记录是否和数据文件一致，不一致时 hint 文件已经失效
+// 一致时返回记录中保存的统计
+func CheckDataHintFooter(footer *LogRecord, dataFile *DataFile) (*DataHintFooter, bool, error) {
+	var values [3]uint64
+	var index = 0
+	for i := range values {
+		v, n := binary.Uvarint(footer.Value[index:])
+		if n <= 0 {
+			return nil, false, ErrInvalidHintRecord
+		}
+		values[i] = v
+		index += n
+	}
+	if len(footer.Value) != index+crc32.Size {
+		return nil, false, ErrInvalidHintRecord
+	}
+	actualSize, actualCRC, err := dataFileChecksum(dataFile)
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(values[0]) != actualSize || binary.LittleEndian.Uint32(footer.Value[index:]) != actualCRC {
+		return nil, false, nil
+	}
+	return &DataHintFooter{ValueSize: int64(values[1]), CompressedValueSize: int64(values[2])}, true, nil
+}
+
+// 数据文件的大小，以及开头和末尾各最多 hintFooterCheckSize 字节的 CRC，不需要读取整个文件
+func dataFileChecksum(dataFile *DataFile) (int64, uint32, error) {
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return 0, 0, err
+	}
+	var crc uint32
+	for _, offset := range []int64{0, max(size-hintFooterCheckSize, 0)} {
+		n := min(size-offset, hintFooterCheckSize)
+		if n == 0 {
+			continue
+		}
+		buf, err := dataFile.readNByte(n, offset)
+		if err != nil {
+			return 0, 0, err
+		}
+		crc = crc32.Update(crc, crc32.IEEETable, buf)
+	}
+	return size, crc, nil
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"time"
 )
 
 type LogRecordType = byte
@@ -11,6 +12,19 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDelete
 	LogRecordTxnFinished = 3
+	// 范围删除，key 是范围的起点，value 是范围的终点（不包含），value 为空表示没有终点
+	LogRecordRangeDelete LogRecordType = 4
+	// hint 文件的最后一条记录，value 是生成 hint 文件时数据文件的大小、末尾数据的校验值和 value 的大小
+	// merge 生成的 hint 索引文件的最后一条记录，value 是 merge 之后每个数据文件中 value 的大小
+	LogRecordHintFooter LogRecordType = 5
+)
+
+// type 字节的低 4 位是记录类型，高位用作标志位
+const (
+	logRecordTypeMask     byte = 0x0f
+	logRecordExpireFlag   byte = 0x80
+	logRecordCompressFlag byte = 0x40
+	logRecordEncryptFlag  byte = 0x20
 )
 
 type LogRecordHeader struct {
@@ -18,17 +32,39 @@ type LogRecordHeader struct {
 	recordType LogRecordType // 标志logRecord类型
 	keySize    uint32        // key的长度
 	valueSize  uint32        // value的长度
+	expire     int64         // 过期时间
+	// value 的压缩算法
+	compression CompressionType
+	encrypted   bool   // key 和 value 是否加密
+	keyId       uint32 // 加密使用的密钥 id
+}
+
+// 记录头之后保存的数据长度
+func (h *LogRecordHeader) payloadSize() int64 {
+	size := int64(h.keySize) + int64(h.valueSize)
+	if h.encrypted {
+		size += EncryptOverhead
+	}
+	return size
 }
+
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType
+	Expire int64 // 过期时间，UnixNano，0 表示永不过期
+	// 写入时使用的压缩算法，压缩之后没有变小的 value 会保存原始数据
+	// 读取时 Value 已经解压，该字段是数据实际保存时使用的压缩算法
+	Compression CompressionType
+	// 读取时 value 压缩之后保存的大小，没有压缩时为 0
+	CompressedSize int64
 }
 
 type LogRecordPos struct {
 	Fid    uint32
 	Offset int64
 	Size   uint32
+	Expire int64 // 过期时间，和 LogRecord 中的保持一致
 }
 
 type Transaction struct {
@@ -36,35 +72,101 @@ type Transaction struct {
 	Pos    *LogRecordPos
 }
 
+// IsExpired 判断记录是否已经过期
+func (lr *LogRecord) IsExpired() bool {
+	return isExpired(lr.Expire)
+}
+
+// StoredValueSize 读取的记录中 value 在数据文件中保存的大小，压缩过时是压缩之后的大小，不包含加密增加的长度
+func (lr *LogRecord) StoredValueSize() int64 {
+	if lr.Compression != CompressionNone {
+		return lr.CompressedSize
+	}
+	return int64(len(lr.Value))
+}
+
+// IsExpired 判断索引指向的记录是否已经过期
+func (pos *LogRecordPos) IsExpired() bool {
+	return isExpired(pos.Expire)
+}
+
+func isExpired(expire int64) bool {
+	return expire > 0 && expire <= time.Now().UnixNano()
+}
+
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
-	//+-----------------------------------------------------------------+
-	//+ crc校验值| type类型｜ key size     | value size   | key | value   |
-	//  4字节    |  1字节  ｜ 变长最大5字节 ｜  变长最大5字节 ｜变长 ｜变长
+	encBytes, size, _ := EncodeLogRecordWithCipher(logRecord, nil)
+	return encBytes, size
+}
+
+// EncodeLogRecordWithCipher 编码记录，c 不为 nil 时加密 key 和 value
+func EncodeLogRecordWithCipher(logRecord *LogRecord, c *Cipher) ([]byte, int64, error) {
+	//+----------------------------------------------------------------------------------------------------------------------+
+	//+ crc校验值| type类型｜ key size     | value size   | expire         | compression | key id       | key | value   |
+	//  4字节    |  1字节  ｜ 变长最大5字节 ｜  变长最大5字节 ｜ 可选，变长最大10字节 ｜ 可选，1字节 ｜ 可选，变长最大5字节 ｜变长 ｜变长
 	//
+	// 加密之后 key 和 value 保存为 nonce + 密文 + 认证标签，记录头中的长度是加密之前的长度
+	value := logRecord.Value
+	var compression = CompressionNone
+	if logRecord.Compression != CompressionNone && len(value) > 0 {
+		if buf, ok := compressValue(logRecord.Compression, value); ok {
+			value, compression = buf, logRecord.Compression
+		}
+	}
 	// 初始化一个 header部分字节数组
 	header := make([]byte, maxLogRecordHeaderSize)
 	header[4] = logRecord.Type
 	var index = 5
 	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
-	var size = index + len(logRecord.Key) + len(logRecord.Value)
+	index += binary.PutVarint(header[index:], int64(len(value)))
+	// 只有设置了过期时间才写入，保证旧格式的数据仍然可以读取
+	if logRecord.Expire != 0 {
+		header[4] |= logRecordExpireFlag
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
+	// 同样只有压缩之后才写入压缩算法
+	if compression != CompressionNone {
+		header[4] |= logRecordCompressFlag
+		header[index] = compression
+		index++
+	}
+
+	payload := make([]byte, len(logRecord.Key)+len(value))
+	copy(payload, logRecord.Key)
+	copy(payload[len(logRecord.Key):], value)
+	if c != nil {
+		keyId, err := c.currentKeyId()
+		if err != nil {
+			return nil, 0, err
+		}
+		header[4] |= logRecordEncryptFlag
+		index += binary.PutUvarint(header[index:], uint64(keyId))
+		// 记录头也参与认证，防止被篡改
+		if payload, err = c.seal(keyId, payload, header[crc32.Size:index]); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	var size = index + len(payload)
 	encBytes := make([]byte, size)
 	// 将header 部分内容拷贝进来
 	copy(encBytes[:index], header[:index])
-	copy(encBytes[index:], logRecord.Key)
-	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
+	copy(encBytes[index:], payload)
 	crc := crc32.ChecksumIEEE(encBytes[4:])
 	binary.LittleEndian.PutUint32(encBytes[:4], crc)
 
-	return encBytes, int64(size)
+	return encBytes, int64(size), nil
 }
 
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
 	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	if pos.Expire != 0 {
+		index += binary.PutVarint(buf[index:], pos.Expire)
+	}
 	return buf[:index]
 }
 
@@ -75,7 +177,22 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	offset, n := binary.Varint(buf[index:])
 	index += n
 	size, n := binary.Varint(buf[index:])
-	return &LogRecordPos{Fid: uint32(fileId), Offset: offset, Size: uint32(size)}
+	index += n
+	// 旧格式的位置信息没有过期时间
+	var expire int64
+	if index < len(buf) {
+		expire, _ = binary.Varint(buf[index:])
+	}
+	return &LogRecordPos{Fid: uint32(fileId), Offset: offset, Size: uint32(size), Expire: expire}
+}
+
+// EncodedValueSize 编码之后的记录中 value 保存的大小，压缩过时是压缩之后的大小，不包含加密增加的长度
+func EncodedValueSize(encRecord []byte) int64 {
+	header, _ := DecoderLogRecorderHeader(encRecord)
+	if header == nil {
+		return 0
+	}
+	return int64(header.valueSize)
 }
 
 func DecoderLogRecorderHeader(buf []byte) (*LogRecordHeader, int64) {
@@ -84,20 +201,52 @@ func DecoderLogRecorderHeader(buf []byte) (*LogRecordHeader, int64) {
 	}
 	header := &LogRecordHeader{
 		crc:        binary.LittleEndian.Uint32((buf[:4])),
-		recordType: buf[4],
+		recordType: buf[4] & logRecordTypeMask,
 	}
 	var index = 5
 
 	keySize, n := binary.Varint(buf[index:])
+	// 长度字段不完整，说明记录被截断了
+	if n <= 0 {
+		return nil, 0
+	}
 	header.keySize = uint32(keySize)
 
 	index += n
 
 	// 	取出实际的value size
 	valueSize, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil, 0
+	}
 	header.valueSize = uint32(valueSize)
 	index += n
 
+	if buf[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.expire = expire
+		index += n
+	}
+	if buf[4]&logRecordCompressFlag != 0 {
+		if index >= len(buf) {
+			return nil, 0
+		}
+		header.compression = buf[index]
+		index++
+	}
+	if buf[4]&logRecordEncryptFlag != 0 {
+		keyId, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return nil, 0
+		}
+		header.encrypted = true
+		header.keyId = uint32(keyId)
+		index += n
+	}
+
 	return header, int64(index)
 }
 
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"hash/crc32"
 	"testing"
@@ -105,3 +106,45 @@ func TestGetLogRecordCRC(t *testing.T) {
 	assert.Equal(t, uint32(290887979), crc3)
 
 }
+
+func TestEncodeLogRecord_Expire(t *testing.T) {
+	rec := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-go"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+	res, n := EncodeLogRecord(rec)
+	assert.Equal(t, int64(len(res)), n)
+
+	header, headerSize := DecoderLogRecorderHeader(res)
+	assert.Equal(t, LogRecordNormal, header.recordType)
+	assert.Equal(t, rec.Expire, header.expire)
+	assert.Equal(t, n, headerSize+int64(header.keySize)+int64(header.valueSize))
+
+	pos := &LogRecordPos{Fid: 1, Offset: 100, Size: 30, Expire: rec.Expire}
+	assert.Equal(t, pos, DecodeLogRecordPos(EncodeLogRecordPos(pos)))
+}
+
+func TestEncodeLogRecord_Compression(t *testing.T) {
+	value := bytes.Repeat([]byte("bitcask kv go "), 100)
+	for _, typ := range []CompressionType{CompressionSnappy, CompressionZstd, CompressionLZ4} {
+		rec := &LogRecord{Key: []byte("name"), Value: value, Compression: typ}
+		enc, size := EncodeLogRecord(rec)
+		assert.True(t, size < int64(len(value)))
+
+		header, headerSize := DecoderLogRecorderHeader(enc)
+		assert.NotNil(t, header)
+		assert.Equal(t, typ, header.compression)
+		decoded, err := decompressValue(typ, enc[headerSize+4:])
+		assert.Nil(t, err)
+		assert.Equal(t, value, decoded)
+	}
+
+	// 压缩之后没有变小的数据保存原始内容
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("a"), Compression: CompressionZstd}
+	enc, _ := EncodeLogRecord(rec)
+	header, _ := DecoderLogRecorderHeader(enc)
+	assert.Equal(t, CompressionNone, header.compression)
+	assert.Equal(t, []byte("a"), enc[len(enc)-1:])
+}
@@ -8,33 +8,49 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gofrs/flock"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const seqNoKey = "seq.no"
 const fileLockName = "flock"
 
+// 启动时每批更新索引的 key 的数量
+const indexLoadBatchSize = 1024
+
 type DB struct {
 	options Options
 	mu      *sync.RWMutex
 	// 文件id只能在加载索引的时候使用，不能在其他地方更新和使用
-	fileIds         []int
-	activeFile      *data.DataFile
-	olderFiles      map[uint32]*data.DataFile
-	index           index.Indexer
-	seqNo           uint64
-	isMerging       bool
-	seqNoFileExists bool //存储事务的序列号
-	isInitial       bool
-	fileLock        *flock.Flock
-	bytesWrite      uint // 当前写了多少个字节
-	reclaimSize     int64
+	fileIds     []int
+	activeFile  *data.DataFile
+	olderFiles  map[uint32]*data.DataFile
+	index       index.Indexer
+	seqNo       uint64
+	isMerging   bool
+	isInitial   bool
+	fileLock    *flock.Flock
+	bytesWrite  uint // 当前写了多少个字节
+	reclaimSize int64
+	deadSizes   map[uint32]int64 // 每个数据文件中可以回收的数据量
+	// 最近一次 merge 开始时的可回收数据量，merge 的结果要下次启动才生效
+	mergedReclaimSize int64
+	closeCh           chan struct{}   // 通知后台任务退出
+	bgTasks           *sync.WaitGroup // 后台任务
+	// 快照对数据文件的引用计数，被引用的文件在快照释放之前不能删除
+	fileRefs             map[*data.DataFile]int
+	retiredFiles         map[*data.DataFile]string // 已经不再使用，等待引用释放之后删除的文件及其路径
+	valueSizes           map[uint32]int64          // 每个数据文件中 value 压缩前的大小
+	compressedValueSizes map[uint32]int64          // 每个数据文件中 value 实际占用的大小
+	cipher               *data.Cipher              // 加密数据使用，为 nil 表示不加密
+	commitQueue          *commitQueue              // 组提交队列，并发的写入合并之后一起写入和持久化
+	checkpointLock       *sync.Mutex               // 同一时间只能有一个索引检查点在写入
+	appliedFileId        uint32                    // B+ 树索引最近一次记录的位置所在的数据文件
 }
 
 // Stat 存储引擎统计信息
@@ -43,11 +59,10 @@ type Stat struct {
 	DataFileNum     uint  // 数据文件的数量
 	ReclaimableSize int64 // 可以进行 merge 回收的数据量，字节为单位
 	DiskSize        int64 // 数据目录所占磁盘空间大小
-}
-
-func (s Stat) Error() string {
-	//TODO implement me
-	panic("implement me")
+	// 当前所有数据文件中 value 压缩前和压缩后的大小，字节为单位
+	ValueSize           int64
+	CompressedValueSize int64
+	DataFiles           []DataFileStat // 每个数据文件的有效和无效数据量，按照文件 id 排序
 }
 
 func Open(options Options) (*DB, error) {
@@ -82,72 +97,150 @@ func Open(options Options) (*DB, error) {
 	}
 
 	db := &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrite),
-		isInitial:  isInitial,
-		fileLock:   fileLock,
+		options:              options,
+		mu:                   new(sync.RWMutex),
+		olderFiles:           make(map[uint32]*data.DataFile),
+		deadSizes:            make(map[uint32]int64),
+		valueSizes:           make(map[uint32]int64),
+		compressedValueSizes: make(map[uint32]int64),
+		index:                index.NewShardedIndexer(options.IndexType, options.DirPath, options.SyncWrite, options.IndexShards),
+		isInitial:            isInitial,
+		fileLock:             fileLock,
+		closeCh:              make(chan struct{}),
+		bgTasks:              new(sync.WaitGroup),
+		fileRefs:             make(map[*data.DataFile]int),
+		retiredFiles:         make(map[*data.DataFile]string),
+		cipher:               newCipher(options),
+		commitQueue:          newCommitQueue(),
+		checkpointLock:       new(sync.Mutex),
+	}
+	if err := db.load(); err != nil {
+		// 加载失败时释放已经打开的文件和文件锁，之后可以换一种恢复方式重新打开
+		db.closeFiles()
+		_ = fileLock.Unlock()
+		return nil, err
+	}
+
+	// 启动后台任务
+	if options.AutoMerge.Enable {
+		db.bgTasks.Add(1)
+		go db.autoMerge()
 	}
+	if options.IndexCheckpointInterval > 0 && options.IndexType != BPlusTree {
+		db.bgTasks.Add(1)
+		go db.autoCheckpointIndex()
+	}
+	return db, nil
+}
+
+// 加载数据文件和索引
+func (db *DB) load() error {
 	if err := db.loadMergeFiles(); err != nil {
-		return nil, err
+		return err
 	}
 	// 加载数据文件
 	if err := db.loadDataFiles(); err != nil {
-		return nil, err
+		return err
 	}
 
-	// B+ 树的索引 不需要从数据文件中加载索引
-	if options.IndexType != BPlusTree {
-		// 从 hint 索引文件中加载索引
+	// 优先从索引检查点中加载，之后只需要加载检查点之后写入的数据
+	// B+ 树的索引保存在磁盘上，只需要重放索引中记录的位置之后写入的数据
+	var checkpoint *indexCheckpoint
+	var err error
+	if db.options.IndexType == BPlusTree {
+		checkpoint, err = db.loadAppliedPosition()
+	} else {
+		checkpoint, err = db.loadIndexCheckpoint()
+	}
+	if err != nil {
+		return err
+	}
+	// 从 hint 索引文件中加载索引，检查点中已经包含了 merge 之后的索引
+	if checkpoint == nil {
 		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+			return err
 		}
-		// 加载索引
-		if err := db.loadIndexFromDataFiles(); err != nil {
-			return nil, err
+	}
+	// 加载索引
+	if err := db.loadIndexFromDataFiles(checkpoint); err != nil {
+		return err
+	}
+	// 重置 IO 类型为用户配置的 IO 类型
+	if db.options.MMapAtStartup {
+		if err := db.resetIOType(); err != nil {
+			return err
 		}
-		// 重置 IO 类型为  标准文件IO
-		if db.options.MMapAtStartup {
-			if err := db.resetIOType(); err != nil {
-				return nil, err
-			}
+	}
+	if db.activeFile != nil {
+		if err := db.activeFile.Preallocate(db.options.DataFileSize); err != nil {
+			return err
 		}
 	}
 
-	if options.IndexType == BPlusTree {
+	if db.options.IndexType == BPlusTree {
 		if err := db.loadSeqNo(); err != nil {
-			return nil, err
+			return err
 		}
-		if db.activeFile != nil {
-			size, err := db.activeFile.IoManager.Size()
-			if err != nil {
-				return nil, err
-			}
-			db.activeFile.WriteOff = size
+		if checkpoint != nil && db.activeFile != nil &&
+			(db.activeFile.FileId != checkpoint.fid || db.activeFile.WriteOff != checkpoint.offset) {
+			db.rebuildDeadSizes()
+		}
+		// 重放完成之后更新位置，下次启动不需要再重放
+		if err := db.saveAppliedPosition(); err != nil {
+			return err
 		}
 	}
-	return db, nil
+	return nil
 }
 
-func (db *DB) Put(key []byte, value []byte) error {
-	if len(key) == 0 {
-		return ErrKeyIsEmpty
+// 关闭所有打开的文件，只在打开数据库失败时使用
+func (db *DB) closeFiles() {
+	_ = db.index.Close()
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
 	}
-	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, NonTransitionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+	for _, dataFile := range db.olderFiles {
+		_ = dataFile.Close()
 	}
+}
 
-	pos, err := db.AppendLogRecordWithLock(logRecord)
-	if err != nil {
-		return err
+func (db *DB) Put(key []byte, value []byte) error {
+	return db.put(key, value, 0)
+}
+
+// PutWithTTL 写入一条带过期时间的数据，过期之后 Get 返回 ErrKeyNotFound
+// ttl 小于等于 0 表示永不过期
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
 	}
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+	return db.put(key, value, expire)
+}
+
+func (db *DB) put(key []byte, value []byte, expire int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
 	}
-	return nil
+	logRecord := &data.LogRecord{
+		Key:    logRecordKeyWithSeq(key, NonTransitionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
+	}
+
+	// 写数据和更新索引由组提交的 leader 依次完成，事务提交时才能检测到冲突
+	return db.commit(&commitRequest{
+		prepare: func(map[string]struct{}) ([]*data.LogRecord, error) {
+			return []*data.LogRecord{logRecord}, nil
+		},
+		apply: func(positions []*data.LogRecordPos) []*data.LogRecordPos {
+			if oldPos := db.index.Put(key, positions[0]); oldPos != nil {
+				return []*data.LogRecordPos{oldPos}
+			}
+			return nil
+		},
+	})
 }
 
 func (db *DB) Get(key []byte) ([]byte, error) {
@@ -157,7 +250,8 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 		return nil, ErrKeyIsEmpty
 	}
 	logRecordPos := db.index.Get(key)
-	if logRecordPos == nil {
+	// 过期的 key 和不存在的 key 一样处理
+	if logRecordPos == nil || logRecordPos.IsExpired() {
 		return nil, ErrKeyNotFound
 	}
 	return db.getValueByPosition(logRecordPos)
@@ -167,29 +261,26 @@ func (db *DB) Delete(key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
-	// 现查找一下内存找key是否存在，如果存在的话直接返回
-	if pos := db.index.Get(key); pos == nil {
-		return nil
-	}
-	// 构建LogRecord 标志其是删除的
-	logRecord := &data.LogRecord{
-		Key:  logRecordKeyWithSeq(key, NonTransitionSeqNo),
-		Type: data.LogRecordDelete,
-	}
-	pos, err := db.AppendLogRecordWithLock(logRecord)
-	if err != nil {
-		return nil
-	}
-	db.reclaimSize += int64(pos.Size)
-	// 从内存中删除
-	oldPos, ok := db.index.Delete(key)
-	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
-	}
-	if !ok {
-		return ErrIndexUpdateFailed
-	}
-	return nil
+	return db.commit(&commitRequest{
+		prepare: func(written map[string]struct{}) ([]*data.LogRecord, error) {
+			// 现查找一下内存找key是否存在，如果存在的话直接返回
+			if _, ok := written[string(key)]; !ok && db.index.Get(key) == nil {
+				return nil, nil
+			}
+			// 构建LogRecord 标志其是删除的
+			return []*data.LogRecord{{
+				Key:  logRecordKeyWithSeq(key, NonTransitionSeqNo),
+				Type: data.LogRecordDelete,
+			}}, nil
+		},
+		apply: func(positions []*data.LogRecordPos) []*data.LogRecordPos {
+			// 从内存中删除，同一组中排在前面的请求可能已经删除了这个 key
+			if oldPos, _ := db.index.Delete(key); oldPos != nil {
+				return []*data.LogRecordPos{positions[0], oldPos}
+			}
+			return []*data.LogRecordPos{positions[0]}
+		},
+	})
 }
 
 // 根据索引信息获取对应的value
@@ -200,6 +291,11 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	} else {
 		dataFile = db.olderFiles[logRecordPos.Fid]
 	}
+	return readValueFromFile(dataFile, logRecordPos)
+}
+
+// 从指定的数据文件中读取 value
+func readValueFromFile(dataFile *data.DataFile, logRecordPos *data.LogRecordPos) ([]byte, error) {
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
 	}
@@ -207,7 +303,7 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 	if err != nil {
 		return nil, err
 	}
-	if logRecord.Type == data.LogRecordDelete {
+	if logRecord.Type == data.LogRecordDelete || logRecord.IsExpired() {
 		return nil, ErrKeyNotFound
 	}
 	return logRecord.Value, nil
@@ -220,31 +316,60 @@ func (db *DB) AppendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecor
 }
 
 func (db *DB) AppendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	positions, err := db.appendLogRecords([]*data.LogRecord{logRecord}, false)
+	if err != nil {
+		return nil, err
+	}
+	return positions[0], nil
+}
 
+// 把一组记录编码之后合并成尽量少的 Write 写入活跃文件，全部写完之后最多持久化一次
+// sync 为 true 或者用户配置了 SyncWrite 时一定会持久化，需要持有 db.mu
+func (db *DB) appendLogRecords(logRecords []*data.LogRecord, sync bool) ([]*data.LogRecordPos, error) {
 	if db.activeFile == nil {
 		if err := db.setActiveDataFile(); err != nil {
 			return nil, err
 		}
 	}
-	encRecord, size := data.EncodeLogRecord(logRecord)
-	if db.activeFile.WriteOff+size > db.options.DataFileSize {
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
+	positions := make([]*data.LogRecordPos, len(logRecords))
+	var buf []byte
+	for i, logRecord := range logRecords {
+		if logRecord.Type == data.LogRecordNormal {
+			logRecord.Compression = db.options.Compression
 		}
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-		if err := db.setActiveDataFile(); err != nil {
+		encRecord, size, err := data.EncodeLogRecordWithCipher(logRecord, db.cipher)
+		if err != nil {
 			return nil, err
 		}
-	}
+		if db.activeFile.WriteOff+int64(len(buf))+size > db.options.DataFileSize {
+			// 先写入已经编码的记录，再打开新的活跃文件
+			if err := db.writeActiveFile(buf); err != nil {
+				return nil, err
+			}
+			buf = buf[:0]
+			if err := db.sealActiveFile(); err != nil {
+				return nil, err
+			}
+			if err := db.setActiveDataFile(); err != nil {
+				return nil, err
+			}
+		}
+		positions[i] = &data.LogRecordPos{
+			Fid:    db.activeFile.FileId,
+			Offset: db.activeFile.WriteOff + int64(len(buf)),
+			Size:   uint32(size),
+			Expire: logRecord.Expire,
+		}
+		buf = append(buf, encRecord...)
 
-	writeOff := db.activeFile.WriteOff
-	if err := db.activeFile.Write(encRecord); err != nil {
+		db.bytesWrite += uint(size)
+		db.addValueSize(db.activeFile.FileId, int64(len(logRecord.Value)), data.EncodedValueSize(encRecord))
+	}
+	if err := db.writeActiveFile(buf); err != nil {
 		return nil, err
 	}
-	db.bytesWrite += uint(size)
 	// 根据用户配置决定是否持久化
-	var needSync = db.options.SyncWrite
+	var needSync = sync || db.options.SyncWrite
 	if !needSync && db.options.BytePerSync > 0 && db.bytesWrite > db.options.BytePerSync {
 		needSync = true
 	}
@@ -256,11 +381,14 @@ func (db *DB) AppendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 			db.bytesWrite = 0
 		}
 	}
-	pos := &data.LogRecordPos{
-		Fid:    db.activeFile.FileId,
-		Offset: writeOff,
+	return positions, nil
+}
+
+func (db *DB) writeActiveFile(buf []byte) error {
+	if len(buf) == 0 {
+		return nil
 	}
-	return pos, nil
+	return db.activeFile.Write(buf)
 }
 
 func (db *DB) setActiveDataFile() error {
@@ -268,14 +396,37 @@ func (db *DB) setActiveDataFile() error {
 	if db.activeFile != nil {
 		initialFileId = db.activeFile.FileId + 1
 	}
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	dataFile, err := db.openDataFile(db.options.DirPath, initialFileId, db.options.IOType)
 	if err != nil {
 		return err
 	}
+	if err := dataFile.Preallocate(db.options.DataFileSize); err != nil {
+		return err
+	}
 	db.activeFile = dataFile
 	return nil
 }
 
+// 打开数据文件，开启了加密时设置加密使用的 Cipher
+func (db *DB) openDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*data.DataFile, error) {
+	dataFile, err := data.OpenDataFile(dirPath, fileId, ioType)
+	if err != nil {
+		return nil, err
+	}
+	dataFile.Cipher = db.cipher
+	return dataFile, nil
+}
+
+func newCipher(options Options) *data.Cipher {
+	if options.KeyProvider != nil {
+		return data.NewCipher(options.KeyProvider)
+	}
+	if len(options.EncryptionKey) > 0 {
+		return data.NewCipher(data.NewStaticKeyProvider(options.EncryptionKey))
+	}
+	return nil
+}
+
 func (db *DB) loadDataFiles() error {
 	dirEntries, err := os.ReadDir(db.options.DirPath)
 	if err != nil {
@@ -283,6 +434,11 @@ func (db *DB) loadDataFiles() error {
 	}
 	var fileIds []int
 	for _, entry := range dirEntries {
+		// 上次 Compact 没有完成时留下的临时文件
+		if strings.HasSuffix(entry.Name(), data.CompactDataFileNameSuffix) {
+			_ = os.Remove(filepath.Join(db.options.DirPath, entry.Name()))
+			continue
+		}
 		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
 			// 0001.data
 			splitNames := strings.Split(entry.Name(), ".")
@@ -298,11 +454,11 @@ func (db *DB) loadDataFiles() error {
 	sort.Ints(fileIds)
 	db.fileIds = fileIds
 	for i, fid := range fileIds {
-		ioType := fio.StandardFIO
+		ioType := db.options.IOType
 		if db.options.MMapAtStartup {
 			ioType = fio.MemoryMap
 		}
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
+		dataFile, err := db.openDataFile(db.options.DirPath, uint32(fid), ioType)
 		if err != nil {
 			return err
 		}
@@ -317,8 +473,8 @@ func (db *DB) loadDataFiles() error {
 }
 
 // 从数据文件中加载索引
-// 遍历文件的所有记录 并更新到内存索引
-func (db *DB) loadIndexFromDataFiles() error {
+// 遍历文件的所有记录 并更新到内存索引，checkpoint 不为空时只加载检查点之后写入的记录
+func (db *DB) loadIndexFromDataFiles(checkpoint *indexCheckpoint) error {
 	// 没有文件说明是空的数据库
 	if len(db.fileIds) == 0 {
 		return nil
@@ -336,77 +492,120 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fid
 	}
 
+	// 索引的更新先攒起来批量执行，B+ 树索引每一批只需要一次事务
+	var ops []index.BatchOp
+	flushIndex := func() {
+		for i, oldPos := range db.index.ApplyBatch(ops) {
+			// B+ 树索引记录的位置落后于索引，重放的记录可能已经在索引中了
+			if oldPos != nil && !samePos(oldPos, ops[i].Pos) {
+				db.addDeadSize(oldPos)
+			}
+		}
+		ops = ops[:0]
+	}
 	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDelete {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size)
+		// 已经过期的数据和删除的数据一样，不需要加载到索引中
+		if typ == data.LogRecordDelete || pos.IsExpired() {
+			ops = append(ops, index.BatchOp{Key: key})
+			db.addDeadSize(pos)
 		} else {
-			oldPos = db.index.Put(key, pos)
+			ops = append(ops, index.BatchOp{Key: key, Pos: pos})
 		}
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
+		if len(ops) >= indexLoadBatchSize {
+			flushIndex()
 		}
 	}
 	// 暂存事务的数据
 	transactionRecords := make(map[uint64][]*data.Transaction)
-	var currentSeqNo = NonTransitionSeqNo
-	// 遍历所有文件id，处理文件中的记录
-	for i, fid := range db.fileIds {
-		var fileId = uint32(fid)
-		// 如果最近未参与 merge的文件 id更小，则说明已经从Hint 文件中加载索引了
-		if hasMerge && fileId < nonMergeFileId {
-			continue
+	// 从检查点中加载的序列号之后继续递增
+	var currentSeqNo = db.seqNo
+	handleRecord := func(fileId uint32, logRecord *data.LogRecord, offset, size int64) {
+		if checkpoint != nil && fileId == checkpoint.fid && offset < checkpoint.offset {
+			return
 		}
-		var dataFile *data.DataFile
-		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
+		// 构建内存索引
+		logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size), Expire: logRecord.Expire}
+
+		// 解析key 拿到seq
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		if seqNo == NonTransitionSeqNo && logRecord.Type == data.LogRecordRangeDelete {
+			// 范围删除只对之前写入的数据生效，按照顺序重放即可
+			flushIndex()
+			db.deleteIndexRange(realKey, logRecord.Value)
+			db.addDeadSize(logRecordPos)
+		} else if seqNo == NonTransitionSeqNo {
+			// 非事务操作直接更新索引
+			updateIndex(realKey, logRecord.Type, logRecordPos)
 		} else {
-			dataFile = db.olderFiles[fileId]
-		}
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
+			//
+			if logRecord.Type == data.LogRecordTxnFinished {
+				for _, txnRecord := range transactionRecords[seqNo] {
+					updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
 				}
-				return err
-			}
-			// 构建内存索引
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size)}
-
-			// 解析key 拿到seq
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == NonTransitionSeqNo {
-				// 非事务操作直接更新索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
+				delete(transactionRecords, seqNo)
 			} else {
-				//
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(transactionRecords, seqNo)
-				} else {
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.Transaction{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
-				}
+				logRecord.Key = realKey
+				transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.Transaction{
+					Record: logRecord,
+					Pos:    logRecordPos,
+				})
 			}
+		}
 
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
-			}
-			// 递增offset 下一次从新的位置开始读取
-			offset += size
+		if seqNo > currentSeqNo {
+			currentSeqNo = seqNo
+		}
+	}
+
+	// 如果最近未参与 merge的文件 id更小，则说明已经从Hint 文件中加载索引了
+	var olderFiles []*data.DataFile
+	for _, fid := range db.fileIds[:len(db.fileIds)-1] {
+		if hasMerge && uint32(fid) < nonMergeFileId {
+			continue
 		}
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		if checkpoint != nil && uint32(fid) < checkpoint.fid {
+			continue
 		}
+		olderFiles = append(olderFiles, db.olderFiles[uint32(fid)])
 	}
+	// 旧的数据文件并行读取，再按照文件 id 的顺序更新索引，保证后写入的数据覆盖先写入的数据
+	err := db.loadDataFilesParallel(olderFiles, db.options.IndexLoadWorkers,
+		func(dataFile *data.DataFile, loaded *loadedDataFile) error {
+			for _, entry := range loaded.entries {
+				handleRecord(dataFile.FileId, entry.logRecord, entry.offset, entry.size)
+			}
+			// 重新读取的文件的统计覆盖检查点中的统计
+			db.valueSizes[dataFile.FileId] = loaded.valueSize
+			db.compressedValueSizes[dataFile.FileId] = loaded.compressedValueSize
+			// 之前没有生成 hint 文件的数据文件在后台补上
+			if !loaded.fromHint {
+				db.mu.Lock()
+				db.writeDataHintFileAsync(dataFile)
+				db.mu.Unlock()
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	// 最后一个文件是活跃文件，末尾可能有写了一半的记录
+	if !hasMerge || db.activeFile.FileId >= nonMergeFileId {
+		var valueSize, compressedValueSize int64
+		offset, err := db.scanDataFile(db.activeFile, true, func(logRecord *data.LogRecord, offset, size int64) error {
+			handleRecord(db.activeFile.FileId, logRecord, offset, size)
+			valueSize += int64(len(logRecord.Value))
+			compressedValueSize += logRecord.StoredValueSize()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		db.activeFile.WriteOff = offset
+		db.valueSizes[db.activeFile.FileId] = valueSize
+		db.compressedValueSizes[db.activeFile.FileId] = compressedValueSize
+	}
+	flushIndex()
 	db.seqNo = currentSeqNo
 	return nil
 }
@@ -417,23 +616,43 @@ func (db *DB) Close() error {
 			panic(fmt.Sprintf("db directory file unlock failed"))
 		}
 	}()
+	// 先停止后台任务，后台任务中可能会持有锁
+	db.stopBackgroundTasks()
+
+	// 记录的位置之前写入的数据需要都已经更新到索引中
+	defer db.pauseCommit()()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.saveAppliedPosition(); err != nil {
+		return err
+	}
+	// 关闭索引
+	if err := db.index.Close(); err != nil {
+		return err
+	}
 	if db.activeFile == nil {
 		return nil
 	}
-	db.mu.Lock()
-	defer db.mu.Unlock()
 
-	// 保存当前事务序列号
+	// 保存当前事务序列号，文件中只保留最新的一条记录
 	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
 	if err != nil {
 		return err
 	}
+	defer seqNoFile.Close()
+	seqNoFile.Cipher = db.cipher
+	if err := seqNoFile.Truncate(0); err != nil {
+		return err
+	}
 
 	record := &data.LogRecord{
 		Key:   []byte(seqNoKey),
 		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
 	}
-	encRecord, _ := data.EncodeLogRecord(record)
+	encRecord, _, err := data.EncodeLogRecordWithCipher(record, db.cipher)
+	if err != nil {
+		return err
+	}
 	err = seqNoFile.Write(encRecord)
 	if err != nil {
 		return err
@@ -451,11 +670,16 @@ func (db *DB) Close() error {
 
 func (db *DB) ListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	defer iterator.Close()
+	keys := make([][]byte, 0, db.index.Size())
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx += 1
+		if iterator.Value().IsExpired() {
+			continue
+		}
+		// 索引迭代器返回的 key 在迭代器关闭之后可能失效，需要拷贝
+		key := make([]byte, len(iterator.Key()))
+		copy(key, iterator.Key())
+		keys = append(keys, key)
 	}
 	return keys
 }
@@ -464,7 +688,11 @@ func (db *DB) Fold(f func(key []byte, value []byte) bool) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 	iterator := db.index.Iterator(false)
+	defer iterator.Close()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().IsExpired() {
+			continue
+		}
 		value, err := db.getValueByPosition(iterator.Value())
 		if err != nil {
 			return err
@@ -485,7 +713,7 @@ func (db *DB) Sync() error {
 	return db.activeFile.Sync()
 }
 
-func (db *DB) Stat() error {
+func (db *DB) Stat() *Stat {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -498,11 +726,15 @@ func (db *DB) Stat() error {
 	if err != nil {
 		panic(fmt.Sprintf("failed to get dir size : %v", err))
 	}
+	valueSize, compressedValueSize := db.totalValueSizes()
 	return &Stat{
-		KeyNum:          uint(db.index.Size()),
-		DataFileNum:     dataFiles,
-		ReclaimableSize: db.reclaimSize,
-		DiskSize:        dirSize,
+		KeyNum:              uint(db.index.Size()),
+		DataFileNum:         dataFiles,
+		ReclaimableSize:     db.reclaimSize,
+		DiskSize:            dirSize,
+		ValueSize:           valueSize,
+		CompressedValueSize: compressedValueSize,
+		DataFiles:           db.dataFileStats(),
 	}
 }
 
@@ -523,6 +755,38 @@ func checkOptions(options Options) error {
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid data file merge ratio")
 	}
+	if options.AutoMerge.Enable {
+		if options.AutoMerge.CheckInterval <= 0 {
+			return errors.New("invalid auto merge check interval")
+		}
+		if options.AutoMerge.MergeRatio < 0 || options.AutoMerge.MergeRatio > 1 {
+			return errors.New("invalid auto merge ratio")
+		}
+	}
+	if options.RecoveryMode < RecoveryFail || options.RecoveryMode > RecoverySalvage {
+		return errors.New("invalid recovery mode")
+	}
+	if options.IndexShards < 0 {
+		return errors.New("invalid index shards")
+	}
+	if options.IndexLoadWorkers < 0 {
+		return errors.New("invalid index load workers")
+	}
+	if options.IndexCheckpointInterval < 0 {
+		return errors.New("invalid index checkpoint interval")
+	}
+	if options.IndexShards > 1 && options.IndexType == BPlusTree {
+		return errors.New("b+ tree index can not be sharded")
+	}
+	if !data.IsValidCompression(options.Compression) {
+		return data.ErrUnsupportedCompression
+	}
+	if options.IOType != StandardIO && options.IOType != DirectIO && options.IOType != MMapIO {
+		return errors.New("invalid io type")
+	}
+	if n := len(options.EncryptionKey); n != 0 && n != 16 && n != 24 && n != 32 {
+		return errors.New("invalid encryption key size, it must be 16, 24 or 32 bytes")
+	}
 	return nil
 }
 
@@ -535,13 +799,18 @@ func (db *DB) loadSeqNo() error {
 	if err != nil {
 		return err
 	}
+	defer seqNoFile.Close()
+	seqNoFile.Cipher = db.cipher
 	record, _, err := seqNoFile.ReadLogRecord(0)
+	if err != nil {
+		return err
+	}
 	seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
 	if err != nil {
 		return err
 	}
-	db.seqNo = seqNo
-	db.seqNoFileExists = true
+	// 重放数据文件之后得到的序列号可能更大
+	db.seqNo = max(db.seqNo, seqNo)
 	return nil
 }
 
@@ -549,11 +818,11 @@ func (db *DB) resetIOType() error {
 	if db.activeFile == nil {
 		return nil
 	}
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+	if err := db.activeFile.SetIOManager(db.options.DirPath, db.options.IOType); err != nil {
 		return err
 	}
 	for _, dataFile := range db.olderFiles {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+		if err := dataFile.SetIOManager(db.options.DirPath, db.options.IOType); err != nil {
 			return err
 		}
 	}
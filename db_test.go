@@ -1,9 +1,13 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/utils"
+	"bytes"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -398,3 +402,284 @@ func TestDB_Open2(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db)
 }
+
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), time.Millisecond*100)
+	assert.Nil(t, err)
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(24), 0)
+	assert.Nil(t, err)
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+
+	// 过期之后读取不到，也不会出现在迭代器中
+	time.Sleep(time.Millisecond * 150)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val2, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.NotNil(t, val2)
+	assert.Equal(t, 1, len(db.ListKeys()))
+
+	// 重启之后过期数据不会被加载
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 1, db2.index.Size())
+	assert.True(t, db2.reclaimSize > 0)
+	err = db2.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression")
+	opts.DirPath = dir
+	opts.Compression = SnappyCompression
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(db.getMergePath())
+	}()
+
+	value := bytes.Repeat([]byte("bitcask-go-value"), 64)
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+	}
+	stat := db.Stat()
+	assert.Equal(t, int64(100*len(value)), stat.ValueSize)
+	assert.True(t, stat.CompressedValueSize < stat.ValueSize/2)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+
+	// 修改压缩算法之后旧的数据仍然可以读取
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.Compression = ZstdCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 100; i < 200; i++ {
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	opts.Compression = NoCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+// 可以轮换密钥的 KeyProvider
+type testKeyProvider struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func (p *testKeyProvider) CurrentKey() (uint32, []byte, error) {
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *testKeyProvider) Key(keyId uint32) ([]byte, error) {
+	key, ok := p.keys[keyId]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return key, nil
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	provider := &testKeyProvider{
+		current: 1,
+		keys:    map[uint32][]byte{1: []byte("0123456789abcdef")},
+	}
+	opts.KeyProvider = provider
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(db.getMergePath())
+	}()
+
+	value := []byte("some secret value")
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+	buf, _ := os.ReadFile(data.GetDataFileName(dir, 0))
+	assert.False(t, bytes.Contains(buf, value))
+
+	// 没有密钥时无法打开
+	plainOpts := opts
+	plainOpts.KeyProvider = nil
+	_, err = Open(plainOpts)
+	assert.Equal(t, data.ErrNoKeyProvider, err)
+
+	// 轮换密钥之后通过 merge 使用新的密钥重写数据
+	provider.current = 2
+	provider.keys[2] = []byte("fedcba9876543210")
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	report, err := VerifyWithKeyProvider(dir, provider)
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.Problems)
+
+	delete(provider.keys, 1)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
+
+func TestDB_DirectIO(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("direct io is only supported on linux")
+	}
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-direct-io")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IOType = DirectIO
+	db, err := Open(opts)
+	defer destroyDB(db)
+	if err != nil {
+		t.Skip("direct io is not supported by the file system:", err)
+	}
+
+	values := make(map[int][]byte)
+	for i := 0; i < 500; i++ {
+		values[i] = utils.RandomValue(100 + i)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	check := func(db *DB) {
+		for i := 0; i < 500; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, values[i], val)
+		}
+	}
+	check(db)
+	assert.True(t, db.Stat().DataFileNum > 1)
+
+	// 启动时使用 mmap 加载，之后切换到 direct io 继续写入
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.MMapAtStartup = true
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	err = db.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	values[1] = []byte("new value")
+	check(db)
+}
+
+func TestDB_MMapIO(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("writable mmap is only supported on unix")
+	}
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-mmap-io")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IOType = MMapIO
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 500; i++ {
+		values[i] = utils.RandomValue(100)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	check := func(db *DB) {
+		for i := 0; i < 500; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, values[i], val)
+		}
+	}
+	check(db)
+	assert.True(t, db.Stat().DataFileNum > 1)
+	// 活跃文件预先分配了空间
+	stat, err := os.Stat(data.GetDataFileName(dir, db.activeFile.FileId))
+	assert.Nil(t, err)
+	assert.Equal(t, opts.DataFileSize, stat.Size())
+
+	// 运行中备份的活跃文件末尾是预先分配的空间，和崩溃之后一样
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-mmap-io-backup")
+	defer func() {
+		_ = os.RemoveAll(backupDir)
+	}()
+	err = db.Sync()
+	assert.Nil(t, err)
+	err = db.Backup(backupDir)
+	assert.Nil(t, err)
+	backupOpts := opts
+	backupOpts.DirPath = backupDir
+	backupDB, err := Open(backupOpts)
+	assert.Nil(t, err)
+	check(backupDB)
+	err = backupDB.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	err = backupDB.Close()
+	assert.Nil(t, err)
+	backupDB, err = Open(backupOpts)
+	assert.Nil(t, err)
+	val, err := backupDB.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+	err = backupDB.Close()
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
@@ -0,0 +1,92 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bytes"
+)
+
+// DeleteRange 删除 [start, end) 范围内的所有 key，end 为空表示删除 start 之后的所有 key
+// 只会写入一条范围删除的记录，删除是原子的
+func (db *DB) DeleteRange(start, end []byte) error {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+	// 不经过组提交，需要等正在进行的组提交更新完索引，范围删除才能覆盖之前写入的 key
+	defer db.pauseCommit()()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// 范围内没有 key 的话不需要写入
+	keys := keysInRange(db.index, start, end)
+	if len(keys) == 0 {
+		return nil
+	}
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(start, NonTransitionSeqNo),
+		Value: end,
+		Type:  data.LogRecordRangeDelete,
+	}
+	pos, err := db.AppendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	db.addDeadSize(pos)
+	db.deleteIndexKeys(keys)
+	return db.saveAppliedPositionOnRotate()
+}
+
+// DeletePrefix 删除所有以 prefix 开头的 key
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.DeleteRange(prefix, prefixUpperBound(prefix))
+}
+
+// 从索引中删除 [start, end) 范围内的 key，需要持有 db.mu
+func (db *DB) deleteIndexRange(start, end []byte) {
+	db.deleteIndexKeys(keysInRange(db.index, start, end))
+}
+
+func (db *DB) deleteIndexKeys(keys [][]byte) {
+	ops := make([]index.BatchOp, len(keys))
+	for i, key := range keys {
+		ops[i] = index.BatchOp{Key: key}
+	}
+	for _, oldPos := range db.index.ApplyBatch(ops) {
+		if oldPos != nil {
+			db.addDeadSize(oldPos)
+		}
+	}
+}
+
+// 查找索引中 [start, end) 范围内的 key，end 为空表示没有上界
+// 先收集 key 再删除，避免在迭代的过程中修改索引
+func keysInRange(indexer index.Indexer, start, end []byte) [][]byte {
+	iterator := indexer.Iterator(false)
+	defer iterator.Close()
+	var keys [][]byte
+	for iterator.Seek(start); iterator.Valid(); iterator.Next() {
+		if len(end) > 0 && bytes.Compare(iterator.Key(), end) >= 0 {
+			break
+		}
+		// B+ 树迭代器返回的 key 在迭代器关闭之后就失效了，需要拷贝
+		key := make([]byte, len(iterator.Key()))
+		copy(key, iterator.Key())
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// 返回比所有以 prefix 开头的 key 都大的最小的 key，prefix 全部是 0xff 时返回 nil 表示没有上界
+func prefixUpperBound(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package bitcask_go
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-range")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(db.getMergePath())
+	}()
+
+	for _, prefix := range []string{"a-", "b-", "c-"} {
+		for i := 0; i < 10; i++ {
+			err := db.Put([]byte(prefix+string(rune('0'+i))), []byte("value"))
+			assert.Nil(t, err)
+		}
+	}
+
+	err = db.DeletePrefix([]byte("b-"))
+	assert.Nil(t, err)
+	err = db.DeleteRange([]byte("a-5"), []byte("a-8"))
+	assert.Nil(t, err)
+	err = db.DeleteRange([]byte("c-5"), []byte("c-1"))
+	assert.Equal(t, ErrInvalidRange, err)
+	// 范围删除之后写入的数据不受影响
+	err = db.Put([]byte("b-1"), []byte("new value"))
+	assert.Nil(t, err)
+
+	check := func(db *DB) {
+		keys := db.ListKeys()
+		assert.Equal(t, 18, len(keys))
+		for _, key := range []string{"a-5", "a-6", "a-7", "b-0", "b-9"} {
+			_, err := db.Get([]byte(key))
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+		for _, key := range []string{"a-4", "a-8", "b-1", "c-0"} {
+			_, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+		}
+		iter := db.NewIterator(IteratorOptions{Prefix: []byte("b-")})
+		defer iter.Close()
+		var count int
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			count++
+		}
+		assert.Equal(t, 1, count)
+	}
+	check(db)
+
+	// 重启之后重放范围删除
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	// merge 之后范围删除的数据被清理
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+}
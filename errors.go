@@ -10,10 +10,19 @@ var (
 	ErrDataFileNotFound       = errors.New("data file is not found")
 
 	ErrDatabaseIsUsing = errors.New("the database is using")
+	ErrDatabaseClosed  = errors.New("the database is closed")
 
 	ErrExceedMaxBatchNum = errors.New(" exceed max batch num")
 
 	ErrNotEnoughSpaceForMerge = errors.New("not enough disk space for merge")
 	ErrMergeRatioUnreached    = errors.New("merge ratio unreached")
 	ErrMergeIsProgress        = errors.New("merge is process, try again")
+
+	ErrSnapshotReleased = errors.New("the snapshot has been released")
+
+	ErrTxnConflict = errors.New("transaction conflict, keys read by the transaction were modified")
+	ErrTxnFinished = errors.New("transaction has been committed or rolled back")
+
+	ErrInvalidRange     = errors.New("the start of the range must be less than the end")
+	ErrKeysOnlyIterator = errors.New("the iterator only iterates keys")
 )
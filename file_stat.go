@@ -0,0 +1,124 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"encoding/binary"
+	"errors"
+	"maps"
+	"sort"
+)
+
+var errInvalidFileSizes = errors.New("invalid data file sizes")
+
+// DataFileStat 单个数据文件的统计信息，字节为单位
+type DataFileStat struct {
+	FileId   uint32
+	Size     int64 // 文件中已经写入的数据量
+	LiveSize int64 // 仍然有效的数据量
+	DeadSize int64 // 被覆盖、删除或者过期，可以回收的数据量
+}
+
+// 记录 pos 指向的数据已经无效，需要持有 db.mu
+func (db *DB) addDeadSize(pos *data.LogRecordPos) {
+	db.reclaimSize += int64(pos.Size)
+	db.deadSizes[pos.Fid] += int64(pos.Size)
+}
+
+// 每个数据文件的统计信息，按照文件 id 排序，需要持有 db.mu
+func (db *DB) dataFileStats() []DataFileStat {
+	var stats []DataFileStat
+	addStat := func(fid uint32, size int64) {
+		// 从旧版本的检查点中恢复时没有每个文件的统计，无效的数据量可能偏小
+		deadSize := min(db.deadSizes[fid], size)
+		stats = append(stats, DataFileStat{
+			FileId:   fid,
+			Size:     size,
+			LiveSize: size - deadSize,
+			DeadSize: deadSize,
+		})
+	}
+	for fid, dataFile := range db.olderFiles {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			size = dataFile.WriteOff
+		}
+		addStat(fid, size)
+	}
+	if db.activeFile != nil {
+		// 活跃文件有预先分配的空间，只统计已经写入的部分
+		addStat(db.activeFile.FileId, db.activeFile.WriteOff)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].FileId < stats[j].FileId
+	})
+	return stats
+}
+
+// 从检查点或者索引中恢复每个文件的无效数据量
+func (db *DB) restoreDeadSizes(deadSizes map[uint32]int64) {
+	for fid, size := range deadSizes {
+		db.deadSizes[fid] += size
+	}
+}
+
+// 记录写入数据文件 fid 的 value 的大小，需要持有 db.mu
+func (db *DB) addValueSize(fid uint32, valueSize, compressedValueSize int64) {
+	db.valueSizes[fid] += valueSize
+	db.compressedValueSizes[fid] += compressedValueSize
+}
+
+// 从检查点、索引或者 merge 的 hint 索引文件中恢复每个文件的 value 大小
+func (db *DB) restoreValueSizes(valueSizes, compressedValueSizes map[uint32]int64) {
+	maps.Copy(db.valueSizes, valueSizes)
+	maps.Copy(db.compressedValueSizes, compressedValueSizes)
+}
+
+// 当前所有数据文件中 value 压缩前和实际占用的大小，merge 或者压缩删除、替换的文件不再统计
+func (db *DB) totalValueSizes() (int64, int64) {
+	var valueSize, compressedValueSize int64
+	add := func(fid uint32) {
+		valueSize += db.valueSizes[fid]
+		compressedValueSize += db.compressedValueSizes[fid]
+	}
+	for fid := range db.olderFiles {
+		add(fid)
+	}
+	if db.activeFile != nil {
+		add(db.activeFile.FileId)
+	}
+	return valueSize, compressedValueSize
+}
+
+// 把每个数据文件的统计追加到 buf 中
+func appendFileSizes(buf []byte, sizes map[uint32]int64) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(sizes)))
+	for fid, size := range sizes {
+		buf = binary.AppendUvarint(buf, uint64(fid))
+		buf = binary.AppendVarint(buf, size)
+	}
+	return buf
+}
+
+// 解码 appendFileSizes 写入的统计，返回剩余的数据
+func decodeFileSizes(buf []byte) (map[uint32]int64, []byte, error) {
+	num, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, nil, errInvalidFileSizes
+	}
+	buf = buf[n:]
+	sizes := make(map[uint32]int64, num)
+	for i := uint64(0); i < num; i++ {
+		fid, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, nil, errInvalidFileSizes
+		}
+		buf = buf[n:]
+		size, n := binary.Varint(buf)
+		if n <= 0 {
+			return nil, nil, errInvalidFileSizes
+		}
+		buf = buf[n:]
+		sizes[uint32(fid)] = size
+	}
+	return sizes, buf, nil
+}
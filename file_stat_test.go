@@ -0,0 +1,143 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_DataFileStats(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, BPlusTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-file-stat")
+		opts.DirPath = dir
+		opts.DataFileSize = 16 * 1024
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		for i := 0; i < 300; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+		}
+		// 前几个文件中的数据全部被覆盖
+		for i := 0; i < 300; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+		}
+		for i := 0; i < 50; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		}
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		for i := 50; i < 60; i++ {
+			assert.Nil(t, wb.Delete(utils.GetTestKey(i)))
+		}
+		assert.Nil(t, wb.Commit())
+
+		stat := db.Stat()
+		assert.Equal(t, int(stat.DataFileNum), len(stat.DataFiles))
+		var deadSize int64
+		for i, fileStat := range stat.DataFiles {
+			assert.Equal(t, uint32(i), fileStat.FileId)
+			assert.Equal(t, fileStat.Size, fileStat.LiveSize+fileStat.DeadSize)
+			deadSize += fileStat.DeadSize
+		}
+		assert.Equal(t, stat.ReclaimableSize, deadSize)
+		assert.Equal(t, stat.DataFiles[0].Size, stat.DataFiles[0].DeadSize)
+		assert.True(t, stat.DataFiles[len(stat.DataFiles)-1].LiveSize > 0)
+
+		// 重启之后统计保持不变：完整加载、从检查点加载和从 B+ 树记录的位置加载
+		assert.Nil(t, db.CheckpointIndex())
+		assert.Nil(t, db.Close())
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, stat.DataFiles, db.Stat().DataFiles)
+		assert.Equal(t, stat.ReclaimableSize, db.Stat().ReclaimableSize)
+
+		// 压缩之后无效数据被回收
+		assert.Nil(t, db.Compact(DefaultCompactOptions))
+		db.bgTasks.Wait()
+		stat = db.Stat()
+		assert.Equal(t, int64(0), stat.DataFiles[0].Size)
+		deadSize = 0
+		for _, fileStat := range stat.DataFiles {
+			deadSize += fileStat.DeadSize
+		}
+		assert.Equal(t, stat.ReclaimableSize, deadSize)
+		assert.Nil(t, db.Close())
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, stat.DataFiles, db.Stat().DataFiles)
+		destroyDB(db)
+	}
+}
+
+func TestDB_ValueSizes(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, BPlusTree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-value-size")
+		opts.DirPath = dir
+		opts.DataFileSize = 16 * 1024
+		opts.IndexType = indexType
+		opts.Compression = SnappyCompression
+		opts.DataFileMergeRatio = 0
+		db, err := Open(opts)
+		assert.Nil(t, err)
+
+		value := bytes.Repeat([]byte("bitcask-go-value"), 8)
+		for i := 0; i < 1000; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		}
+		// 前面的文件中的数据被覆盖
+		for i := 0; i < 500; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		}
+		stat := db.Stat()
+		assert.Equal(t, int64(1500*len(value)), stat.ValueSize)
+		assert.True(t, stat.CompressedValueSize < stat.ValueSize/2)
+
+		// 重启之后统计保持不变：从检查点或者 B+ 树记录的位置、hint 文件和数据文件加载
+		assert.Nil(t, db.CheckpointIndex())
+		db.bgTasks.Wait()
+		assert.Nil(t, db.Close())
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, stat.ValueSize, db.Stat().ValueSize)
+		assert.Equal(t, stat.CompressedValueSize, db.Stat().CompressedValueSize)
+		assert.Nil(t, db.Close())
+		files, _ := filepath.Glob(filepath.Join(dir, "*"+data.DataHintFileNameSuffix))
+		files = append(files, filepath.Join(dir, data.IndexCheckpointFileName), filepath.Join(dir, index.BptreeIndexFileName))
+		for _, file := range files {
+			assert.Nil(t, os.RemoveAll(file))
+		}
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, stat.ValueSize, db.Stat().ValueSize)
+		assert.Equal(t, stat.CompressedValueSize, db.Stat().CompressedValueSize)
+
+		// 压缩之后不再统计被覆盖的数据
+		assert.Nil(t, db.Compact(DefaultCompactOptions))
+		db.bgTasks.Wait()
+		stat = db.Stat()
+		assert.True(t, stat.ValueSize < int64(1500*len(value)))
+		assert.Nil(t, db.Close())
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, stat.ValueSize, db.Stat().ValueSize)
+		assert.Equal(t, stat.CompressedValueSize, db.Stat().CompressedValueSize)
+
+		// merge 之后只剩下有效的数据
+		assert.Nil(t, db.Merge())
+		assert.Nil(t, db.Close())
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		stat = db.Stat()
+		assert.Equal(t, int64(1000*len(value)), stat.ValueSize)
+		assert.True(t, stat.CompressedValueSize < stat.ValueSize/2)
+		_ = os.RemoveAll(db.getMergePath())
+		destroyDB(db)
+	}
+}
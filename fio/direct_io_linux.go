@@ -0,0 +1,155 @@
+//go:build linux
+
+package fio
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// O_DIRECT 要求读写的内存地址、文件偏移和长度都按块对齐
+const directIOAlignment = 4096
+
+// DirectIO 使用 O_DIRECT 绕过页缓存读写文件
+// 文件末尾不满一个块的数据保存在内存中，追加写入时和新的数据一起按块写回
+// 写入之后文件的大小按块对齐，实际的大小保存在内存中，持久化和关闭时才截断，崩溃之后留在文件末尾的是全 0 的数据
+type DirectIO struct {
+	fd     *os.File
+	size   int64  // 文件的实际大小
+	tail   []byte // 文件最后一个不完整的块中的数据
+	padded bool   // 文件末尾是否有按块对齐补上的数据
+}
+
+func NewDirectIOManager(fileName string) (*DirectIO, error) {
+	fd, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|syscall.O_DIRECT, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	dio := &DirectIO{fd: fd}
+	if err := dio.loadTail(); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return dio, nil
+}
+
+// 读取文件大小和最后一个不完整的块
+func (dio *DirectIO) loadTail() error {
+	stat, err := dio.fd.Stat()
+	if err != nil {
+		return err
+	}
+	dio.size = stat.Size()
+	dio.tail = nil
+	dio.padded = false
+	if n := dio.size % directIOAlignment; n > 0 {
+		buf := alignedBlock(directIOAlignment)
+		if _, err := dio.fd.ReadAt(buf, dio.size-n); err != nil && err != io.EOF {
+			return err
+		}
+		dio.tail = append([]byte(nil), buf[:n]...)
+	}
+	return nil
+}
+
+func (dio *DirectIO) Read(b []byte, offset int64) (int, error) {
+	if offset >= dio.size {
+		return 0, io.EOF
+	}
+	start := alignDown(offset)
+	end := alignUp(offset + int64(len(b)))
+	buf := alignedBlock(int(end - start))
+	n, err := dio.fd.ReadAt(buf, start)
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+	// 不读取按块对齐补上的数据
+	n = int(min(int64(n), dio.size-start))
+	n = copy(b, buf[offset-start:max(int64(n), offset-start)])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Write 追加写入，和文件末尾不完整的块拼接之后按块写入
+func (dio *DirectIO) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	start := dio.size - int64(len(dio.tail))
+	length := len(dio.tail) + len(b)
+	buf := alignedBlock(int(alignUp(int64(length))))
+	copy(buf, dio.tail)
+	copy(buf[len(dio.tail):], b)
+	if _, err := dio.fd.WriteAt(buf, start); err != nil {
+		return 0, err
+	}
+	dio.size += int64(len(b))
+	dio.tail = append(dio.tail[:0], buf[alignDown(int64(length)):length]...)
+	dio.padded = len(dio.tail) > 0
+	return len(b), nil
+}
+
+// Sync 持久化之前把文件截断到实际的大小
+func (dio *DirectIO) Sync() error {
+	if err := dio.truncatePadding(); err != nil {
+		return err
+	}
+	return dio.fd.Sync()
+}
+
+func (dio *DirectIO) Close() error {
+	if err := dio.truncatePadding(); err != nil {
+		_ = dio.fd.Close()
+		return err
+	}
+	return dio.fd.Close()
+}
+
+// 截断按块对齐补上的数据，之后追加写入时会重新补上
+func (dio *DirectIO) truncatePadding() error {
+	if !dio.padded {
+		return nil
+	}
+	if err := dio.fd.Truncate(dio.size); err != nil {
+		return err
+	}
+	dio.padded = false
+	return nil
+}
+
+func (dio *DirectIO) Size() (int64, error) {
+	return dio.size, nil
+}
+
+func (dio *DirectIO) Truncate(size int64) error {
+	if err := dio.fd.Truncate(size); err != nil {
+		return err
+	}
+	return dio.loadTail()
+}
+
+// 分配按块对齐的内存
+func alignedBlock(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	offset := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1))
+	if offset != 0 {
+		offset = directIOAlignment - offset
+	}
+	return buf[offset : offset+size]
+}
+
+func alignDown(n int64) int64 {
+	return n &^ (directIOAlignment - 1)
+}
+
+func alignUp(n int64) int64 {
+	return alignDown(n + directIOAlignment - 1)
+}
+
+func newDirectIOManager(fileName string) (IOManager, error) {
+	return NewDirectIOManager(fileName)
+}
@@ -0,0 +1,77 @@
+package fio
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectIO(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-direct-io")
+	defer deStoryFile(dir)
+	path := filepath.Join(dir, "a.data")
+	dio, err := NewDirectIOManager(path)
+	if err != nil {
+		t.Skip("direct io is not supported by the file system:", err)
+	}
+
+	// 写入的数据跨越多个块，长度和偏移都没有对齐
+	var expected []byte
+	for _, size := range []int{13, 4000, 200, 9000, 1} {
+		b := make([]byte, size)
+		for i := range b {
+			b[i] = byte(len(expected) + i)
+		}
+		n, err := dio.Write(b)
+		assert.Nil(t, err)
+		assert.Equal(t, size, n)
+		expected = append(expected, b...)
+	}
+	// 写入时不截断文件，文件的大小按块对齐，持久化时才截断到实际的大小
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), stat.Size()%directIOAlignment)
+	assert.Nil(t, dio.Sync())
+	stat, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(expected)), stat.Size())
+	size, err := dio.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(expected)), size)
+
+	b := make([]byte, 5000)
+	n, err := dio.Read(b, 3000)
+	assert.Nil(t, err)
+	assert.Equal(t, 5000, n)
+	assert.Equal(t, expected[3000:8000], b)
+	// 读到文件末尾
+	n, err = dio.Read(b, int64(len(expected)-100))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 100, n)
+	assert.Equal(t, expected[len(expected)-100:], b[:100])
+	// 不会读到按块对齐补上的数据
+	_, err = dio.Write([]byte("tail"))
+	assert.Nil(t, err)
+	expected = append(expected, []byte("tail")...)
+	n, err = dio.Read(b, int64(len(expected)-10))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, expected[len(expected)-10:], b[:10])
+	assert.Nil(t, dio.Close())
+
+	// 重新打开之后继续追加
+	dio, err = NewDirectIOManager(path)
+	assert.Nil(t, err)
+	assert.Nil(t, dio.Truncate(5000))
+	expected = expected[:5000]
+	_, err = dio.Write([]byte("bitcask"))
+	assert.Nil(t, err)
+	expected = append(expected, []byte("bitcask")...)
+	assert.Nil(t, dio.Close())
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, content)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package fio
+
+// 只有 Linux 支持 O_DIRECT
+func newDirectIOManager(fileName string) (IOManager, error) {
+	return nil, ErrDirectIONotSupported
+}
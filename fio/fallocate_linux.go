@@ -0,0 +1,16 @@
+package fio
+
+import (
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// 为文件 [offset, offset+length) 的范围分配磁盘空间，超过文件大小时同时扩大文件
+// 文件系统不支持时返回 errFallocateNotSupported
+func fallocate(fd *os.File, offset, length int64) error {
+	err := unix.Fallocate(int(fd.Fd()), 0, offset, length)
+	if err == unix.EOPNOTSUPP || err == unix.ENOSYS {
+		return errFallocateNotSupported
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build unix && !linux
+
+package fio
+
+import "os"
+
+// 只有 Linux 支持 fallocate
+func fallocate(fd *os.File, offset, length int64) error {
+	return errFallocateNotSupported
+}
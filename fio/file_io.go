@@ -36,3 +36,7 @@ func (fio *FileIO) Sync() error {
 func (fio *FileIO) Close() error {
 	return fio.fd.Close()
 }
+
+func (fio *FileIO) Truncate(size int64) error {
+	return fio.fd.Truncate(size)
+}
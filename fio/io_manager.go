@@ -1,12 +1,19 @@
 package fio
 
+import "errors"
+
 const DataFilePerm = 0644
 
+var ErrDirectIONotSupported = errors.New("direct io is only supported on linux")
+
 type FileIOType = byte
 
 const (
 	StandardFIO FileIOType = iota
+	// 可读写的内存映射，不支持的系统上只能读取
 	MemoryMap
+	// 使用 O_DIRECT 绕过页缓存，只支持 Linux
+	DirectFIO
 )
 
 type IOManager interface {
@@ -15,6 +22,13 @@ type IOManager interface {
 	Sync() error
 	Close() error
 	Size() (int64, error)
+	// 截断文件到指定的长度
+	Truncate(int64) error
+}
+
+// Preallocator 支持预先分配文件空间的 IOManager
+type Preallocator interface {
+	Preallocate(size int64) error
 }
 
 func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
@@ -27,6 +41,10 @@ func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 		{
 			return NewMMapIOManager(fileName)
 		}
+	case DirectFIO:
+		{
+			return newDirectIOManager(fileName)
+		}
 	default:
 		panic("unsupported io type")
 	}
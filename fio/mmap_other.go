@@ -0,0 +1,69 @@
+//go:build !unix
+
+package fio
+
+import (
+	"errors"
+	"golang.org/x/exp/mmap"
+	"os"
+)
+
+var ErrMMapWriteNotSupported = errors.New("writable mmap is only supported on unix")
+
+// MMap 不支持可写 mmap 的系统上只能用来读取数据
+type MMap struct {
+	fileName string
+	readerAt *mmap.ReaderAt
+}
+
+// 初始化mmap
+func NewMMapIOManager(filename string) (*MMap, error) {
+	_, err := os.OpenFile(filename, os.O_CREATE, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	readerAt, err := mmap.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &MMap{fileName: filename, readerAt: readerAt}, nil
+}
+func (mmap *MMap) Read(b []byte, offset int64) (int, error) {
+	return mmap.readerAt.ReadAt(b, offset)
+}
+
+func (mmap *MMap) Write(b []byte) (int, error) {
+	return 0, ErrMMapWriteNotSupported
+}
+
+func (mmap *MMap) Sync() error {
+	return ErrMMapWriteNotSupported
+}
+
+func (mmap *MMap) Close() error {
+	return mmap.readerAt.Close()
+}
+
+func (mmap *MMap) Size() (int64, error) {
+	return int64(mmap.readerAt.Len()), nil
+}
+
+// 截断文件之后需要重新映射
+func (mmap *MMap) Truncate(size int64) error {
+	if err := mmap.readerAt.Close(); err != nil {
+		return err
+	}
+	if err := os.Truncate(mmap.fileName, size); err != nil {
+		return err
+	}
+	readerAt, err := mmapOpen(mmap.fileName)
+	if err != nil {
+		return err
+	}
+	mmap.readerAt = readerAt
+	return nil
+}
+
+func mmapOpen(filename string) (*mmap.ReaderAt, error) {
+	return mmap.Open(filename)
+}
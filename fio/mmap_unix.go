@@ -0,0 +1,194 @@
+//go:build unix
+
+package fio
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"sync"
+)
+
+var errFallocateNotSupported = errors.New("fallocate is not supported")
+
+// MMap 可读写的内存映射文件
+// 文件用 fallocate 预先分配磁盘空间之后映射到内存中，写入时直接拷贝到映射的内存，空间不够时扩大文件并重新映射
+// 磁盘空间不足时扩大文件返回 ENOSPC；如果只是 truncate 出稀疏文件，写入映射的内存时才分配磁盘空间，失败会触发 SIGBUS
+// 不支持 fallocate 的文件系统上改为用 pwrite 写入文件，映射的内存只用来读取
+// 预先分配的空间在关闭时截断，崩溃之后留在文件末尾的是全 0 的数据
+type MMap struct {
+	fd           *os.File
+	mu           *sync.RWMutex
+	data         []byte // 映射的内存，长度等于文件实际占用的大小
+	size         int64  // 已经写入的数据大小
+	preallocSize int64  // 扩大文件时至少扩大到的大小
+	writeThrough bool   // 文件系统不支持 fallocate，写入时直接写文件
+}
+
+// 初始化mmap
+func NewMMapIOManager(filename string) (*MMap, error) {
+	fd, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := fd.Stat()
+	if err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	mmap := &MMap{fd: fd, mu: new(sync.RWMutex), size: stat.Size()}
+	if err := mmap.remap(stat.Size()); err != nil {
+		_ = fd.Close()
+		return nil, err
+	}
+	return mmap, nil
+}
+
+// 把文件扩大或者缩小到 length 之后重新映射
+func (mmap *MMap) remap(length int64) error {
+	if mmap.data != nil {
+		if err := unix.Munmap(mmap.data); err != nil {
+			return err
+		}
+		mmap.data = nil
+	}
+	stat, err := mmap.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if length > stat.Size() && !mmap.writeThrough {
+		err := fallocate(mmap.fd, stat.Size(), length-stat.Size())
+		if err == errFallocateNotSupported {
+			mmap.writeThrough = true
+		} else if err != nil {
+			// 分配失败时重新映射原来的大小，之前写入的数据仍然可以读取
+			if remapErr := mmap.mapFile(stat.Size()); remapErr != nil {
+				return remapErr
+			}
+			return err
+		}
+	}
+	if length != stat.Size() {
+		if err := mmap.fd.Truncate(length); err != nil {
+			return err
+		}
+	}
+	return mmap.mapFile(length)
+}
+
+// 把文件的前 length 字节映射到内存
+func (mmap *MMap) mapFile(length int64) error {
+	if length == 0 {
+		return nil
+	}
+	data, err := unix.Mmap(int(mmap.fd.Fd()), 0, int(length), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	mmap.data = data
+	return nil
+}
+
+func (mmap *MMap) Read(b []byte, offset int64) (int, error) {
+	mmap.mu.RLock()
+	defer mmap.mu.RUnlock()
+	if offset >= mmap.size {
+		return 0, io.EOF
+	}
+	n := copy(b, mmap.data[offset:mmap.size])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (mmap *MMap) Write(b []byte) (int, error) {
+	mmap.mu.Lock()
+	defer mmap.mu.Unlock()
+	need := mmap.size + int64(len(b))
+	if need > int64(len(mmap.data)) {
+		// 每次至少扩大一倍，减少重新映射的次数
+		length := max(need, mmap.preallocSize, 2*int64(len(mmap.data)))
+		err := mmap.remap(length)
+		// 磁盘空间不够预先分配时只扩大到需要的大小
+		if err == unix.ENOSPC && length > need {
+			err = mmap.remap(need)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if mmap.writeThrough {
+		// 写入文件时分配磁盘空间，空间不足时返回 ENOSPC，写入的数据通过共享的页缓存在映射的内存中可见
+		if _, err := mmap.fd.WriteAt(b, mmap.size); err != nil {
+			return 0, err
+		}
+	} else {
+		copy(mmap.data[mmap.size:], b)
+	}
+	mmap.size = need
+	return len(b), nil
+}
+
+// Preallocate 预先把文件扩大到 size，之后扩大文件时也至少扩大到 size
+func (mmap *MMap) Preallocate(size int64) error {
+	mmap.mu.Lock()
+	defer mmap.mu.Unlock()
+	mmap.preallocSize = size
+	if size <= int64(len(mmap.data)) {
+		return nil
+	}
+	return mmap.remap(size)
+}
+
+func (mmap *MMap) Sync() error {
+	mmap.mu.RLock()
+	defer mmap.mu.RUnlock()
+	if mmap.size == 0 {
+		return nil
+	}
+	return unix.Msync(mmap.data[:mmap.size], unix.MS_SYNC)
+}
+
+// Close 关闭之前把文件截断到实际写入的大小
+func (mmap *MMap) Close() error {
+	mmap.mu.Lock()
+	defer mmap.mu.Unlock()
+	if mmap.data != nil {
+		if err := unix.Munmap(mmap.data); err != nil {
+			return err
+		}
+		mmap.data = nil
+	}
+	stat, err := mmap.fd.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() != mmap.size {
+		if err := mmap.fd.Truncate(mmap.size); err != nil {
+			return err
+		}
+	}
+	return mmap.fd.Close()
+}
+
+func (mmap *MMap) Size() (int64, error) {
+	mmap.mu.RLock()
+	defer mmap.mu.RUnlock()
+	return mmap.size, nil
+}
+
+// Truncate 把文件截断到 size，同时释放预先分配的空间
+func (mmap *MMap) Truncate(size int64) error {
+	mmap.mu.Lock()
+	defer mmap.mu.Unlock()
+	if size > mmap.size {
+		return nil
+	}
+	if err := mmap.remap(size); err != nil {
+		return err
+	}
+	mmap.size = size
+	return nil
+}
@@ -0,0 +1,105 @@
+//go:build unix
+
+package fio
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMMap_Write(t *testing.T) {
+	path := filepath.Join("/tmp", "mmap-b.data")
+	defer deStoryFile(path)
+	mmapIO, err := NewMMapIOManager(path)
+	assert.Nil(t, err)
+	err = mmapIO.Preallocate(64)
+	assert.Nil(t, err)
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(64), stat.Size())
+
+	// 超过预先分配的空间之后扩大文件
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		b := bytes.Repeat([]byte{byte('a' + i)}, 10)
+		n, err := mmapIO.Write(b)
+		assert.Nil(t, err)
+		assert.Equal(t, 10, n)
+		expected = append(expected, b...)
+	}
+	assert.Nil(t, mmapIO.Sync())
+	size, err := mmapIO.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), size)
+	b := make([]byte, 20)
+	n, err := mmapIO.Read(b, 55)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, n)
+	assert.Equal(t, expected[55:75], b)
+	n, err = mmapIO.Read(b, 90)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 10, n)
+
+	err = mmapIO.Truncate(50)
+	assert.Nil(t, err)
+	_, err = mmapIO.Write([]byte("bitcask"))
+	assert.Nil(t, err)
+	expected = append(expected[:50], []byte("bitcask")...)
+	// 关闭之后文件截断到实际写入的大小
+	assert.Nil(t, mmapIO.Close())
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, content)
+}
+
+func TestMMap_Preallocate(t *testing.T) {
+	path := filepath.Join("/tmp", "mmap-c.data")
+	defer deStoryFile(path)
+	mmapIO, err := NewMMapIOManager(path)
+	assert.Nil(t, err)
+	defer mmapIO.Close()
+	err = mmapIO.Preallocate(1 << 20)
+	assert.Nil(t, err)
+	if mmapIO.writeThrough {
+		t.Skip("fallocate is not supported")
+	}
+	// 预先分配的空间已经占用磁盘，不是稀疏文件
+	stat, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, stat.Sys().(*syscall.Stat_t).Blocks*512, int64(1<<20))
+}
+
+func TestMMap_WriteThrough(t *testing.T) {
+	path := filepath.Join("/tmp", "mmap-d.data")
+	defer deStoryFile(path)
+	mmapIO, err := NewMMapIOManager(path)
+	assert.Nil(t, err)
+	// 模拟不支持 fallocate 的文件系统
+	mmapIO.writeThrough = true
+	err = mmapIO.Preallocate(64)
+	assert.Nil(t, err)
+
+	var expected []byte
+	for i := 0; i < 10; i++ {
+		b := bytes.Repeat([]byte{byte('a' + i)}, 10)
+		n, err := mmapIO.Write(b)
+		assert.Nil(t, err)
+		assert.Equal(t, 10, n)
+		expected = append(expected, b...)
+	}
+	b := make([]byte, 20)
+	n, err := mmapIO.Read(b, 55)
+	assert.Nil(t, err)
+	assert.Equal(t, 20, n)
+	assert.Equal(t, expected[55:75], b)
+
+	assert.Nil(t, mmapIO.Close())
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, content)
+}
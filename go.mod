@@ -4,12 +4,16 @@ go 1.21.1
 
 require (
 	github.com/gofrs/flock v0.8.1
+	github.com/golang/snappy v0.0.4
 	github.com/google/btree v1.1.2
+	github.com/klauspost/compress v1.17.11
+	github.com/pierrec/lz4/v4 v4.1.21
 	github.com/plar/go-adaptive-radix-tree v1.0.5
 	github.com/stretchr/testify v1.8.4
 	github.com/tidwall/redcon v1.6.2
 	go.etcd.io/bbolt v1.3.9
 	golang.org/x/exp v0.0.0-20240318143956-a85f2c67cd81
+	golang.org/x/sys v0.4.0
 )
 
 require (
@@ -17,6 +21,5 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/tidwall/btree v1.1.0 // indirect
 	github.com/tidwall/match v1.1.1 // indirect
-	golang.org/x/sys v0.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,166 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// 把当前的活跃文件转为旧的数据文件，并在后台生成 hint 文件
+// 需要持有 db.mu，调用之后需要打开新的活跃文件
+func (db *DB) sealActiveFile() error {
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	// 释放预先分配的空间，之后数据文件不会再被修改
+	if err := db.activeFile.Truncate(db.activeFile.WriteOff); err != nil {
+		return err
+	}
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	db.writeDataHintFileAsync(db.activeFile)
+	return nil
+}
+
+// 数据文件写满之后在后台为它生成 hint 文件，启动时可以直接从 hint 文件加载索引，不需要读取 value
+// 需要持有 db.mu
+func (db *DB) writeDataHintFileAsync(dataFile *data.DataFile) {
+	files := map[uint32]*data.DataFile{dataFile.FileId: dataFile}
+	// 生成的过程中文件不能被关闭
+	db.pinDataFiles(files)
+	db.bgTasks.Add(1)
+	go func() {
+		defer db.bgTasks.Done()
+		defer db.unpinDataFiles(files)
+		// 数据库关闭时放弃生成，下次启动时重新读取数据文件即可
+		if err := db.writeDataHintFile(dataFile); err != nil && err != ErrDatabaseClosed {
+			log.Printf("failed to write hint file for data file %d: %v", dataFile.FileId, err)
+		}
+	}()
+}
+
+// 遍历数据文件，把每条记录的 key 和位置写到 hint 文件中
+// 先写到临时文件再重命名，hint 文件存在时一定是完整的
+func (db *DB) writeDataHintFile(dataFile *data.DataFile) error {
+	var buf []byte
+	var sizes data.DataHintFooter
+	_, err := db.scanDataFile(dataFile, false, func(logRecord *data.LogRecord, offset, size int64) error {
+		select {
+		case <-db.closeCh:
+			return ErrDatabaseClosed
+		default:
+		}
+		sizes.ValueSize += int64(len(logRecord.Value))
+		sizes.CompressedValueSize += logRecord.StoredValueSize()
+		hintRecord := data.NewDataHintRecord(logRecord, offset, size)
+		encRecord, _, err := data.EncodeLogRecordWithCipher(hintRecord, db.cipher)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, encRecord...)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	footer, err := data.NewDataHintFooter(dataFile, &sizes)
+	if err != nil {
+		return err
+	}
+	encFooter, _, err := data.EncodeLogRecordWithCipher(footer, db.cipher)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, encFooter...)
+
+	fileName := data.GetDataHintFileName(db.options.DirPath, dataFile.FileId)
+	// 同一个文件 id 的 hint 文件可能同时有多个在生成（Compact 替换了数据文件），临时文件不能相同
+	file, err := os.CreateTemp(db.options.DirPath, filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpFileName := file.Name()
+	if _, err := file.Write(buf); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmpFileName)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmpFileName)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(tmpFileName)
+		return err
+	}
+
+	// 数据文件已经被替换时，生成的 hint 文件中的位置已经失效
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.olderFiles[dataFile.FileId] != dataFile {
+		return os.Remove(tmpFileName)
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// 读取数据文件对应的 hint 文件中的全部记录和数据文件中 value 的大小，hint 文件不存在或者已经失效时返回 false
+// hint 文件的结尾记录中保存了生成时数据文件的大小和末尾数据的 CRC，和数据文件不一致时说明数据文件被修改或者替换过
+func (db *DB) readDataHintFile(dataFile *data.DataFile) (*loadedDataFile, bool, error) {
+	fileName := data.GetDataHintFileName(db.options.DirPath, dataFile.FileId)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	hintFile, err := data.OpenDataHintFile(db.options.DirPath, dataFile.FileId)
+	if err != nil {
+		return nil, false, err
+	}
+	defer hintFile.Close()
+	hintFile.Cipher = db.cipher
+
+	var entries []*hintEntry
+	var offset int64 = 0
+	for {
+		hintRecord, size, err := hintFile.ReadLogRecord(offset)
+		// 没有结尾记录的 hint 文件不能确定是否和数据文件一致
+		if err == io.EOF {
+			log.Printf("hint file of data file %d has no footer, load from data file", dataFile.FileId)
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if hintRecord.Type == data.LogRecordHintFooter {
+			sizes, ok, err := data.CheckDataHintFooter(hintRecord, dataFile)
+			if err != nil {
+				return nil, false, err
+			}
+			if !ok {
+				log.Printf("hint file of data file %d does not match the data file, load from data file", dataFile.FileId)
+				return nil, false, nil
+			}
+			return &loadedDataFile{
+				entries:             entries,
+				fromHint:            true,
+				valueSize:           sizes.ValueSize,
+				compressedValueSize: sizes.CompressedValueSize,
+			}, true, nil
+		}
+		logRecord, recordOffset, recordSize, err := data.ParseDataHintRecord(hintRecord)
+		if err != nil {
+			return nil, false, err
+		}
+		entries = append(entries, &hintEntry{logRecord: logRecord, offset: recordOffset, size: recordSize})
+		offset += size
+	}
+}
+
+// hint 文件中的一条记录，对应数据文件中的一条记录
+type hintEntry struct {
+	logRecord *data.LogRecord
+	offset    int64
+	size      int64
+}
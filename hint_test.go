@@ -0,0 +1,107 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_DataHintFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-data-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(1), []byte("batch value")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(200)))
+	assert.Nil(t, wb.Commit())
+	err = db.DeleteRange(utils.GetTestKey(300), utils.GetTestKey(310))
+	assert.Nil(t, err)
+	for i := 1000; i < 1500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	keyNum := len(db.ListKeys())
+	fileNum := db.Stat().DataFileNum
+	assert.True(t, fileNum > 2)
+
+	// 写满的数据文件都在后台生成了 hint 文件，关闭时还没有完成的会被放弃
+	for fid := uint32(0); fid < uint32(fileNum)-1; fid++ {
+		waitDataHintFile(t, dir, fid)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(data.GetDataHintFileName(dir, uint32(fileNum)-1))
+	assert.True(t, os.IsNotExist(err))
+
+	check := func(db *DB) {
+		assert.Equal(t, keyNum, len(db.ListKeys()))
+		val, err := db.Get(utils.GetTestKey(1))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("batch value"), val)
+		for _, i := range []int{0, 99, 200, 300, 309} {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Equal(t, ErrKeyNotFound, err)
+		}
+		for _, i := range []int{100, 310, 1499} {
+			_, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+		}
+	}
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.Problems)
+
+	// hint 文件损坏时从数据文件中加载，缺少的 hint 文件会重新生成
+	err = os.WriteFile(data.GetDataHintFileName(dir, 0), []byte("corrupted hint file"), 0644)
+	assert.Nil(t, err)
+	err = os.Remove(data.GetDataHintFileName(dir, 1))
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	waitDataHintFile(t, dir, 1)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// hint 文件生成之后数据文件被修改过，和 hint 文件的结尾记录不一致，需要重新遍历数据文件，能发现数据文件的损坏
+	// 恢复修改时间之后也能发现
+	stat, err := os.Stat(data.GetDataFileName(dir, 2))
+	assert.Nil(t, err)
+	f, err := os.OpenFile(data.GetDataFileName(dir, 2), os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte{0xff}, 20)
+	assert.Nil(t, err)
+	_ = f.Close()
+	assert.Nil(t, os.Chtimes(data.GetDataFileName(dir, 2), stat.ModTime(), stat.ModTime()))
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+}
+
+func waitDataHintFile(t *testing.T, dir string, fileId uint32) {
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(data.GetDataHintFileName(dir, fileId))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
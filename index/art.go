@@ -3,31 +3,34 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
+	"github.com/google/btree"
 	goart "github.com/plar/go-adaptive-radix-tree"
 	"sort"
 	"sync"
 )
 
 type AdaptiveRadixTree struct {
-	tree goart.Tree
-	lock *sync.RWMutex
+	tree  goart.Tree
+	lock  *sync.RWMutex
+	views map[*artView]struct{} // 还在使用的视图，修改 key 之前需要把旧的位置保存到视图中
 }
 
 func NewART() *AdaptiveRadixTree {
 	return &AdaptiveRadixTree{
-		tree: goart.New(),
-		lock: new(sync.RWMutex),
+		tree:  goart.New(),
+		lock:  new(sync.RWMutex),
+		views: make(map[*artView]struct{}),
 	}
 }
 
 func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.lock.Lock()
+	art.saveToViews(key)
 	oldValue, _ := art.tree.Insert(key, pos)
 	art.lock.Unlock()
 	if oldValue == nil {
 		return nil
 	}
-	art.tree.Insert(key, pos)
 	return oldValue.(*data.LogRecordPos)
 }
 func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
@@ -42,6 +45,7 @@ func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
 
 func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.lock.Lock()
+	art.saveToViews(key)
 	oldValue, deleted := art.tree.Delete(key)
 	art.lock.Unlock()
 	if oldValue == nil {
@@ -50,6 +54,25 @@ func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	return oldValue.(*data.LogRecordPos), deleted
 }
 
+func (art *AdaptiveRadixTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	for i, op := range ops {
+		art.saveToViews(op.Key)
+		var oldValue goart.Value
+		if op.Pos == nil {
+			oldValue, _ = art.tree.Delete(op.Key)
+		} else {
+			oldValue, _ = art.tree.Insert(op.Key, op.Pos)
+		}
+		if oldValue != nil {
+			oldPositions[i] = oldValue.(*data.LogRecordPos)
+		}
+	}
+	return oldPositions
+}
+
 func (art *AdaptiveRadixTree) Size() int {
 	art.lock.RLock()
 	size := art.tree.Size()
@@ -58,68 +81,319 @@ func (art *AdaptiveRadixTree) Size() int {
 }
 
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
+	return art.RangeIterator(reverse, nil, nil)
+}
+
+func (art *AdaptiveRadixTree) RangeIterator(reverse bool, lower, upper []byte) Iterator {
+	return newArtIterator(art, art.newView(), reverse, lower, upper)
+}
+
+// Clone 基数树不支持写时复制，返回基于视图的只读副本，创建的代价很小
+// 副本存在期间每个被修改的 key 都会在视图中保存一份旧的位置，使用完之后需要调用 Close 释放
+func (art *AdaptiveRadixTree) Clone() Indexer {
+	view := art.newView()
 	art.lock.RLock()
-	defer art.lock.RUnlock()
-	return newARTIterator(art.tree, reverse)
+	size := art.tree.Size()
+	art.lock.RUnlock()
+	return &artSnapshot{art: art, view: view, size: size}
 }
 
 func (art *AdaptiveRadixTree) Close() error {
 	return nil
 }
 
-type artIterator struct {
-	currIndex int
-	reverse   bool
-	values    []*Item
+// artView 基数树在创建时的只读视图
+// 基数树不支持写时复制，创建之后被修改的 key 在第一次修改之前的位置保存在 changed 中，
+// 读取时优先使用 changed 中的位置，占用的内存只和视图存在期间修改的 key 的数量有关
+type artView struct {
+	changed *btree.BTree // pos 为 nil 表示创建视图时 key 不存在
+}
+
+func (art *AdaptiveRadixTree) newView() *artView {
+	return art.copyView(nil)
+}
+
+// 创建一个和 view 内容相同的视图，view 为 nil 时创建当前时刻的视图
+func (art *AdaptiveRadixTree) copyView(view *artView) *artView {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	newView := &artView{changed: btree.New(32)}
+	if view != nil {
+		// btree 的 Clone 是写时复制的
+		newView.changed = view.changed.Clone()
+	}
+	art.views[newView] = struct{}{}
+	return newView
 }
 
-func newARTIterator(tree goart.Tree, reverse bool) *artIterator {
-	var idx int
-	values := make([]*Item, tree.Size())
-	if reverse {
-		idx = tree.Size() - 1
+func (art *AdaptiveRadixTree) releaseView(view *artView) {
+	art.lock.Lock()
+	delete(art.views, view)
+	art.lock.Unlock()
+}
+
+// 修改 key 之前把它当前的位置保存到还没有保存过这个 key 的视图中，需要持有写锁
+func (art *AdaptiveRadixTree) saveToViews(key []byte) {
+	if len(art.views) == 0 {
+		return
+	}
+	item := &Item{key: key}
+	if value, found := art.tree.Search(key); found {
+		item.pos = value.(*data.LogRecordPos)
 	}
-	saveValues := func(node goart.Node) bool {
-		item := &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
+	for view := range art.views {
+		if !view.changed.Has(item) {
+			view.changed.ReplaceOrInsert(item)
 		}
-		values[idx] = item
-		if reverse {
-			idx--
-		} else {
-			idx++
+	}
+}
+
+// 按顺序取出视图中从 start 开始的一批数据，inclusive 表示是否包含 start，需要持有读锁
+// 树中取出的数据和视图中保存的旧位置归并之后返回，done 表示之后已经没有更多的数据
+func (art *AdaptiveRadixTree) ascendView(view *artView, start []byte, inclusive bool, upper []byte,
+	values []*Item) ([]*Item, bool) {
+	n := len(values)
+	for {
+		// 两边最多各取一批，只有都取到的范围内的结果是完整的
+		var treeItems, changedItems []*Item
+		ascendTree(art.tree, start, inclusive, func(key []byte, pos *data.LogRecordPos) bool {
+			if len(upper) > 0 && bytes.Compare(key, upper) >= 0 {
+				return false
+			}
+			treeItems = append(treeItems, &Item{key: key, pos: pos})
+			return len(treeItems) < artIteratorBatchSize
+		})
+		view.changed.AscendGreaterOrEqual(&Item{key: start}, func(it btree.Item) bool {
+			item := it.(*Item)
+			if !inclusive && bytes.Equal(item.key, start) {
+				return true
+			}
+			if len(upper) > 0 && bytes.Compare(item.key, upper) >= 0 {
+				return false
+			}
+			changedItems = append(changedItems, item)
+			return len(changedItems) < artIteratorBatchSize
+		})
+		var end []byte
+		if len(treeItems) == artIteratorBatchSize {
+			end = treeItems[len(treeItems)-1].key
+		}
+		if len(changedItems) == artIteratorBatchSize {
+			if last := changedItems[len(changedItems)-1].key; end == nil || bytes.Compare(last, end) < 0 {
+				end = last
+			}
+		}
+
+		var i, j int
+		for i < len(treeItems) || j < len(changedItems) {
+			var item *Item
+			switch {
+			case j == len(changedItems):
+				item, i = treeItems[i], i+1
+			case i == len(treeItems):
+				item, j = changedItems[j], j+1
+			default:
+				cmp := bytes.Compare(treeItems[i].key, changedItems[j].key)
+				if cmp <= 0 {
+					item, i = treeItems[i], i+1
+				}
+				// 修改过的 key 使用视图中保存的位置
+				if cmp >= 0 {
+					item, j = changedItems[j], j+1
+				}
+			}
+			if end != nil && bytes.Compare(item.key, end) > 0 {
+				break
+			}
+			if item.pos != nil {
+				values = append(values, item)
+			}
+		}
+		if end == nil {
+			return values, true
+		}
+		// 这一批中的 key 在视图中都不存在时继续取下一批
+		if len(values) > n {
+			return values, false
+		}
+		start, inclusive = end, false
+	}
+}
+
+// 按顺序遍历树中大于等于 start 的 key，inclusive 为 false 时不包含 start，f 返回 false 时停止
+// 基数树不支持定位，大于 start 的 key 按照和 start 第一个不同的字节分成多组，每一组有相同的前缀，
+// 依次用 ForEachPrefix 遍历，不需要从头跳过 start 之前的 key
+func ascendTree(tree goart.Tree, start []byte, inclusive bool, f func(key []byte, pos *data.LogRecordPos) bool) {
+	var stopped bool
+	visit := func(node goart.Node) bool {
+		if node.Kind() != goart.Leaf {
+			return true
+		}
+		key := node.Key()
+		if !inclusive && bytes.Equal(key, start) {
+			return true
+		}
+		if !f(key, node.Value().(*data.LogRecordPos)) {
+			stopped = true
+			return false
 		}
 		return true
 	}
-	tree.ForEach(saveValues)
-	return &artIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
+	if len(start) == 0 {
+		tree.ForEach(visit)
+		return
+	}
+	// 以 start 开头的 key
+	tree.ForEachPrefix(start, visit)
+	prefix := make([]byte, len(start))
+	for i := len(start) - 1; i >= 0 && !stopped; i-- {
+		// 前 i 个字节和 start 相同，第 i 个字节比 start 大的 key
+		copy(prefix, start[:i])
+		for c := int(start[i]) + 1; c <= 0xff && !stopped; c++ {
+			prefix[i] = byte(c)
+			tree.ForEachPrefix(prefix[:i+1], visit)
+		}
+	}
+}
+
+// 迭代器每次从树中取出的元素数量
+const artIteratorBatchSize = 256
+
+// artIterator 按批次从树中取数据，只在取数据时持有读锁
+// 迭代器创建时注册一个视图，之后的写入对迭代器不可见，关闭迭代器之后视图才会被释放
+// 基数树只能正向遍历，反向遍历时先正向遍历一次范围，记录每一批的第一个 key，再从后往前逐批取数据
+type artIterator struct {
+	art       *AdaptiveRadixTree
+	view      *artView
+	reverse   bool
+	lower     []byte  // 下界，包含
+	upper     []byte  // 上界，不包含
+	values    []*Item // 当前批次的数据
+	currIndex int
+	start     []byte // 正向遍历时下一批数据从这个 key 开始
+	inclusive bool   // 正向遍历时下一批数据是否包含 start
+	done      bool   // 正向遍历时范围内已经没有更多的数据
+	marks     [][]byte
+	markIndex int // 反向遍历时当前批次在 marks 中的位置
+}
+
+func newArtIterator(art *AdaptiveRadixTree, view *artView, reverse bool, lower, upper []byte) *artIterator {
+	ai := &artIterator{
+		art:     art,
+		view:    view,
+		reverse: reverse,
+		lower:   lower,
+		upper:   upper,
+		values:  make([]*Item, 0, artIteratorBatchSize),
 	}
+	ai.Rewind()
+	return ai
 }
 
 func (ai *artIterator) Rewind() {
-	ai.currIndex = 0
+	if ai.reverse {
+		ai.markRange()
+		ai.fillReverse(len(ai.marks) - 1)
+		return
+	}
+	ai.seekTo(ai.lower)
 }
 
 // 根据key 查找第一个大于或者小于key的元素
 func (ai *artIterator) Seek(key []byte) {
 	if ai.reverse {
-		ai.currIndex = sort.Search(len(ai.values), func(i int) bool {
-			return bytes.Compare(ai.values[i].key, key) <= 0
+		if ai.marks == nil {
+			ai.markRange()
+		}
+		// 最后一个不大于 key 的批次
+		i := sort.Search(len(ai.marks), func(i int) bool {
+			return bytes.Compare(ai.marks[i], key) > 0
 		})
-	} else {
+		ai.fillReverse(i - 1)
 		ai.currIndex = sort.Search(len(ai.values), func(i int) bool {
-			return bytes.Compare(ai.values[i].key, key) >= 0
+			return bytes.Compare(ai.values[i].key, key) <= 0
 		})
+		return
 	}
+	if bytes.Compare(key, ai.lower) < 0 {
+		key = ai.lower
+	}
+	ai.seekTo(key)
+}
+
+// 从 start 开始重新取数据
+func (ai *artIterator) seekTo(start []byte) {
+	ai.start = start
+	ai.inclusive = true
+	ai.done = false
+	ai.fill()
 }
 
 // 跳转到下一个key
 func (ai *artIterator) Next() {
 	ai.currIndex += 1
+	if ai.currIndex < len(ai.values) {
+		return
+	}
+	if ai.reverse {
+		if ai.markIndex > 0 {
+			ai.fillReverse(ai.markIndex - 1)
+		}
+	} else if !ai.done {
+		ai.fill()
+	}
+}
+
+// 从上一批的最后一个 key 之后取出下一批数据
+func (ai *artIterator) fill() {
+	ai.art.lock.RLock()
+	defer ai.art.lock.RUnlock()
+	ai.values, ai.done = ai.art.ascendView(ai.view, ai.start, ai.inclusive, ai.upper, ai.values[:0])
+	ai.currIndex = 0
+	if !ai.done {
+		ai.start, ai.inclusive = ai.values[len(ai.values)-1].key, false
+	}
+}
+
+// 正向遍历一次范围，记录每一批的第一个 key
+func (ai *artIterator) markRange() {
+	ai.marks = ai.marks[:0]
+	start, inclusive, done := ai.lower, true, false
+	for !done {
+		ai.art.lock.RLock()
+		ai.values, done = ai.art.ascendView(ai.view, start, inclusive, ai.upper, ai.values[:0])
+		ai.art.lock.RUnlock()
+		if len(ai.values) > 0 {
+			ai.marks = append(ai.marks, ai.values[0].key)
+			start, inclusive = ai.values[len(ai.values)-1].key, false
+		}
+	}
+}
+
+// 取出第 i 批的数据并倒序排列，i 小于 0 时没有数据
+func (ai *artIterator) fillReverse(i int) {
+	ai.values = ai.values[:0]
+	ai.currIndex = 0
+	ai.markIndex = max(i, 0)
+	if i < 0 {
+		return
+	}
+	end := ai.upper
+	if i+1 < len(ai.marks) {
+		end = ai.marks[i+1]
+	}
+	ai.art.lock.RLock()
+	// 视图不会变化，同一批的数据和记录时相同
+	start, inclusive := ai.marks[i], true
+	for done := false; !done; {
+		if ai.values, done = ai.art.ascendView(ai.view, start, inclusive, end, ai.values); !done {
+			start, inclusive = ai.values[len(ai.values)-1].key, false
+		}
+	}
+	ai.art.lock.RUnlock()
+	for l, r := 0, len(ai.values)-1; l < r; l, r = l+1, r-1 {
+		ai.values[l], ai.values[r] = ai.values[r], ai.values[l]
+	}
 }
 
 func (ai *artIterator) Valid() bool {
@@ -135,5 +409,69 @@ func (ai *artIterator) Value() *data.LogRecordPos {
 }
 
 func (ai *artIterator) Close() {
+	if ai.view != nil {
+		ai.art.releaseView(ai.view)
+		ai.view = nil
+	}
 	ai.values = nil
+	ai.marks = nil
+}
+
+// artSnapshot 基数树在某一时刻的只读副本，和原来的树共享数据，通过视图看到创建时的状态
+type artSnapshot struct {
+	art  *AdaptiveRadixTree
+	view *artView
+	size int
+}
+
+func (as *artSnapshot) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	panic("cannot modify a read-only art snapshot")
+}
+
+func (as *artSnapshot) Get(key []byte) *data.LogRecordPos {
+	as.art.lock.RLock()
+	defer as.art.lock.RUnlock()
+	// 创建之后被修改过的 key 使用视图中保存的位置
+	if item := as.view.changed.Get(&Item{key: key}); item != nil {
+		return item.(*Item).pos
+	}
+	value, found := as.art.tree.Search(key)
+	if !found {
+		return nil
+	}
+	return value.(*data.LogRecordPos)
+}
+
+func (as *artSnapshot) Delete(key []byte) (*data.LogRecordPos, bool) {
+	panic("cannot modify a read-only art snapshot")
+}
+
+func (as *artSnapshot) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	panic("cannot modify a read-only art snapshot")
+}
+
+func (as *artSnapshot) Size() int {
+	return as.size
+}
+
+func (as *artSnapshot) Iterator(reverse bool) Iterator {
+	return as.RangeIterator(reverse, nil, nil)
+}
+
+// RangeIterator 迭代器使用视图的副本，关闭迭代器和关闭快照互不影响
+func (as *artSnapshot) RangeIterator(reverse bool, lower, upper []byte) Iterator {
+	return newArtIterator(as.art, as.art.copyView(as.view), reverse, lower, upper)
+}
+
+func (as *artSnapshot) Clone() Indexer {
+	return &artSnapshot{art: as.art, view: as.art.copyView(as.view), size: as.size}
+}
+
+// Close 释放视图，重复调用不会有影响
+func (as *artSnapshot) Close() error {
+	if as.view != nil {
+		as.art.releaseView(as.view)
+		as.view = nil
+	}
+	return nil
 }
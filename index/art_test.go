@@ -2,7 +2,9 @@ package index
 
 import (
 	"bitcask-go/data"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"sort"
 	"testing"
 )
 
@@ -76,3 +78,67 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestAdaptiveRadixTree_Seek(t *testing.T) {
+	art := NewART()
+	var keys []string
+	// 包含互为前缀的 key 和 0、0xff 字节
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("%d", i*7919%10007)
+		if i%5 == 0 {
+			key += "\x00"
+		}
+		if i%7 == 0 {
+			key += "\xff"
+		}
+		keys = append(keys, key)
+		art.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	sort.Strings(keys)
+
+	iter := art.Iterator(false)
+	defer iter.Close()
+	for _, target := range []string{"", "0", "1", "5\x00", "5000", "9999\xff", "99999", "a"} {
+		expected := keys[sort.SearchStrings(keys, target):]
+		got := make([]string, 0, len(expected))
+		for iter.Seek([]byte(target)); iter.Valid(); iter.Next() {
+			got = append(got, string(iter.Key()))
+		}
+		assert.Equal(t, expected, got, target)
+	}
+}
+
+func TestAdaptiveRadixTree_Clone(t *testing.T) {
+	art := NewART()
+	for i := 0; i < 1000; i++ {
+		art.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	clone := art.Clone()
+	art.Put([]byte("key-0001"), &data.LogRecordPos{Fid: 2})
+	art.Delete([]byte("key-0002"))
+	art.Put([]byte("new"), &data.LogRecordPos{Fid: 2})
+
+	assert.Equal(t, uint32(1), clone.Get([]byte("key-0001")).Fid)
+	assert.Equal(t, int64(2), clone.Get([]byte("key-0002")).Offset)
+	assert.Nil(t, clone.Get([]byte("new")))
+	assert.Equal(t, 1000, clone.Size())
+	assert.Equal(t, 1000, art.Size())
+
+	// 副本的副本和迭代器看到的都是副本创建时的数据
+	clone2 := clone.Clone()
+	art.Delete([]byte("key-0003"))
+	assert.Nil(t, clone.Close())
+	iter := clone2.RangeIterator(true, nil, []byte("key-0005"))
+	var keys []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, uint32(1), iter.Value().Fid)
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"key-0004", "key-0003", "key-0002", "key-0001", "key-0000"}, keys)
+	iter.Close()
+	assert.Nil(t, clone2.Close())
+	assert.Panics(t, func() { clone2.Put([]byte("key"), &data.LogRecordPos{}) })
+
+	// 全部释放之后不再保存旧的位置
+	assert.Equal(t, 0, len(art.views))
+}
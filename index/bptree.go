@@ -2,14 +2,19 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
 	"go.etcd.io/bbolt"
 	"path/filepath"
 )
 
-const bptreeIndexFileName = "bptree-index"
+const BptreeIndexFileName = "bptree-index"
 
 var indexBucketName = []byte("bitcask-index")
 
+// 保存索引元数据的 bucket，和索引分开，不会出现在迭代器中
+var metaBucketName = []byte("bitcask-meta")
+var appliedPositionKey = []byte("applied-position")
+
 type BPlusTree struct {
 	tree *bbolt.DB
 }
@@ -29,12 +34,15 @@ func (bpt *BPlusTree) Size() int {
 func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BptreeIndexFileName), 0644, opts)
 	if err != nil {
 		panic("failed to open bptree")
 	}
 	if err := bptree.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(indexBucketName)
+		if _, err := tx.CreateBucketIfNotExists(indexBucketName); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(metaBucketName)
 		return err
 	}); err != nil {
 		panic("failed to create bucket in bptree")
@@ -89,8 +97,107 @@ func (bpt *BPlusTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	return data.DecodeLogRecordPos(oldVal), true
 }
 
+// ApplyBatch 在一个 bbolt 事务中执行所有的更新，只需要一次提交
+func (bpt *BPlusTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	if len(ops) == 0 {
+		return oldPositions
+	}
+	if err := bpt.tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(indexBucketName)
+		for i, op := range ops {
+			if oldVal := bucket.Get(op.Key); len(oldVal) != 0 {
+				oldPositions[i] = data.DecodeLogRecordPos(oldVal)
+			}
+			var err error
+			if op.Pos == nil {
+				err = bucket.Delete(op.Key)
+			} else {
+				err = bucket.Put(op.Key, data.EncodeLogRecordPos(op.Pos))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		panic("failed to apply batch in bptree")
+	}
+	return oldPositions
+}
+
 func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
-	return newBptreeIterator(bpt.tree, reverse)
+	return newBptreeIterator(bpt.tree, reverse, nil, nil)
+}
+
+func (bpt *BPlusTree) RangeIterator(reverse bool, lower, upper []byte) Iterator {
+	return newBptreeIterator(bpt.tree, reverse, lower, upper)
+}
+
+// Clone B+ 树的数据在磁盘上，复制到内存中的 BTree
+func (bpt *BPlusTree) Clone() Indexer {
+	return bpt.BeginClone()()
+}
+
+// BeginClone 开始一个只读事务，返回的函数在这个事务中把数据复制到内存中的 BTree
+// 调用方只需要在开始事务时持有锁，复制数据时不会阻塞对索引的写入
+func (bpt *BPlusTree) BeginClone() func() Indexer {
+	tx, err := bpt.tree.Begin(false)
+	if err != nil {
+		panic("failed to begin a transaction")
+	}
+	return func() Indexer {
+		defer func() { _ = tx.Rollback() }()
+		bt := NewBTree()
+		if err := tx.Bucket(indexBucketName).ForEach(func(k, v []byte) error {
+			key := make([]byte, len(k))
+			copy(key, k)
+			bt.Put(key, data.DecodeLogRecordPos(v))
+			return nil
+		}); err != nil {
+			panic("failed to clone bptree")
+		}
+		return bt
+	}
+}
+
+// AppliedPosition 读取已经应用到索引中的数据文件位置，没有记录时返回 nil
+func (bpt *BPlusTree) AppliedPosition() (*AppliedPosition, error) {
+	var pos *AppliedPosition
+	err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		value := tx.Bucket(metaBucketName).Get(appliedPositionKey)
+		if len(value) == 0 {
+			return nil
+		}
+		var err error
+		pos, err = decodeAppliedPosition(value)
+		return err
+	})
+	return pos, err
+}
+
+// SetAppliedPosition 记录已经应用到索引中的数据文件位置，pos 为 nil 时清除记录
+func (bpt *BPlusTree) SetAppliedPosition(pos *AppliedPosition) error {
+	return bpt.tree.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(metaBucketName)
+		if pos == nil {
+			return bucket.Delete(appliedPositionKey)
+		}
+		return bucket.Put(appliedPositionKey, pos.encode())
+	})
+}
+
+// Reset 删除并重新创建索引的 bucket，同时清除记录的位置
+func (bpt *BPlusTree) Reset() error {
+	return bpt.tree.Update(func(tx *bbolt.Tx) error {
+		if err := tx.DeleteBucket(indexBucketName); err != nil && err != bbolt.ErrBucketNotFound {
+			return err
+		}
+		if _, err := tx.CreateBucket(indexBucketName); err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucketName).Delete(appliedPositionKey)
+	})
 }
 
 func (bpt *BPlusTree) Close() error {
@@ -101,11 +208,13 @@ type bptreeIterator struct {
 	tx        *bbolt.Tx
 	cursor    *bbolt.Cursor
 	reverse   bool
+	lower     []byte // 下界，包含
+	upper     []byte // 上界，不包含
 	currKey   []byte
 	currValue []byte
 }
 
-func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
+func newBptreeIterator(tree *bbolt.DB, reverse bool, lower, upper []byte) *bptreeIterator {
 	tx, err := tree.Begin(false)
 	if err != nil {
 		panic("failed to begin a transaction")
@@ -114,6 +223,8 @@ func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
 		tx:      tx,
 		cursor:  tx.Bucket(indexBucketName).Cursor(),
 		reverse: reverse,
+		lower:   lower,
+		upper:   upper,
 	}
 	bpi.Rewind()
 	return bpi
@@ -121,14 +232,53 @@ func newBptreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
 
 func (bpi *bptreeIterator) Rewind() {
 	if bpi.reverse {
-		bpi.currKey, bpi.currValue = bpi.cursor.Last()
+		if len(bpi.upper) > 0 {
+			bpi.seekLessThan(bpi.upper)
+		} else {
+			bpi.currKey, bpi.currValue = bpi.cursor.Last()
+		}
 	} else {
-		bpi.currKey, bpi.currValue = bpi.cursor.First()
+		if len(bpi.lower) > 0 {
+			bpi.currKey, bpi.currValue = bpi.cursor.Seek(bpi.lower)
+		} else {
+			bpi.currKey, bpi.currValue = bpi.cursor.First()
+		}
 	}
 }
 
+// 根据key 查找第一个大于或者小于key的元素
 func (bpi *bptreeIterator) Seek(key []byte) {
+	if bpi.reverse {
+		// 超过上界时从上界开始
+		if len(bpi.upper) > 0 && bytes.Compare(key, bpi.upper) >= 0 {
+			bpi.seekLessThan(bpi.upper)
+			return
+		}
+		bpi.currKey, bpi.currValue = bpi.cursor.Seek(key)
+		if !bytes.Equal(bpi.currKey, key) {
+			bpi.prevOrLast()
+		}
+	} else {
+		if len(bpi.lower) > 0 && bytes.Compare(key, bpi.lower) < 0 {
+			key = bpi.lower
+		}
+		bpi.currKey, bpi.currValue = bpi.cursor.Seek(key)
+	}
+}
+
+// 定位到最后一个小于 key 的元素
+func (bpi *bptreeIterator) seekLessThan(key []byte) {
 	bpi.currKey, bpi.currValue = bpi.cursor.Seek(key)
+	bpi.prevOrLast()
+}
+
+// 当前位置之前的元素，当前位置已经超过最后一个元素时返回最后一个元素
+func (bpi *bptreeIterator) prevOrLast() {
+	if bpi.currKey == nil {
+		bpi.currKey, bpi.currValue = bpi.cursor.Last()
+	} else {
+		bpi.currKey, bpi.currValue = bpi.cursor.Prev()
+	}
 }
 
 func (bpi *bptreeIterator) Next() {
@@ -140,7 +290,7 @@ func (bpi *bptreeIterator) Next() {
 }
 
 func (bpi *bptreeIterator) Valid() bool {
-	return len(bpi.currKey) != 0
+	return len(bpi.currKey) != 0 && inRange(bpi.currKey, bpi.lower, bpi.upper)
 }
 
 func (bpi *bptreeIterator) Key() []byte {
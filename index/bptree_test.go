@@ -107,3 +107,82 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestBPlusTree_BeginClone(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-clone")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+	defer tree.Close()
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1})
+	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1})
+	clone := tree.BeginClone()
+	// 开始复制之后的写入不可见，写入可能需要等待只读事务结束，不能在同一个 goroutine 中执行
+	done := make(chan struct{})
+	go func() {
+		tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 2})
+		tree.Put([]byte("acc"), &data.LogRecordPos{Fid: 2})
+		close(done)
+	}()
+
+	bt := clone()
+	<-done
+	assert.Equal(t, 2, bt.Size())
+	assert.Equal(t, uint32(1), bt.Get([]byte("abc")).Fid)
+	assert.Nil(t, bt.Get([]byte("acc")))
+	assert.Equal(t, 3, tree.Size())
+}
+
+func TestBPlusTree_AppliedPosition(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-applied")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+	pos, err := tree.AppliedPosition()
+	assert.Nil(t, err)
+	assert.Nil(t, pos)
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	err = tree.SetAppliedPosition(&AppliedPosition{Fid: 3, Offset: 1024, SeqNo: 7})
+	assert.Nil(t, err)
+	// 位置不会出现在索引中
+	assert.Equal(t, 1, tree.Size())
+	assert.Nil(t, tree.Close())
+
+	tree = NewBPlusTree(path, false)
+	pos, err = tree.AppliedPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, &AppliedPosition{Fid: 3, Offset: 1024, SeqNo: 7}, pos)
+	assert.Nil(t, tree.SetAppliedPosition(nil))
+	pos, err = tree.AppliedPosition()
+	assert.Nil(t, err)
+	assert.Nil(t, pos)
+
+	// 每个数据文件的统计
+	applied := &AppliedPosition{Fid: 3, Offset: 1024, SeqNo: 7,
+		DeadSizes:            map[uint32]int64{1: 100, 2: 50},
+		ValueSizes:           map[uint32]int64{1: 300, 2: 200, 3: 10},
+		CompressedValueSizes: map[uint32]int64{1: 150, 2: 200, 3: 10},
+	}
+	assert.Nil(t, tree.SetAppliedPosition(applied))
+	pos, err = tree.AppliedPosition()
+	assert.Nil(t, err)
+	assert.Equal(t, applied, pos)
+
+	// Reset 同时清空索引和位置
+	assert.Nil(t, tree.SetAppliedPosition(&AppliedPosition{Fid: 3, Offset: 1024, SeqNo: 7}))
+	assert.Nil(t, tree.Reset())
+	assert.Equal(t, 0, tree.Size())
+	assert.Nil(t, tree.Get([]byte("aac")))
+	pos, err = tree.AppliedPosition()
+	assert.Nil(t, err)
+	assert.Nil(t, pos)
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	assert.Equal(t, 1, tree.Size())
+	assert.Nil(t, tree.Close())
+}
@@ -4,7 +4,6 @@ import (
 	"bitcask-go/data"
 	"bytes"
 	"github.com/google/btree"
-	"sort"
 	"sync"
 )
 
@@ -33,7 +32,9 @@ func (bt *BTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 
 func (bt *BTree) Get(key []byte) *data.LogRecordPos {
 	it := &Item{key: key}
+	bt.lock.RLock()
 	btreeItem := bt.tree.Get(it)
+	bt.lock.RUnlock()
 	if btreeItem == nil {
 		return nil
 	}
@@ -51,6 +52,34 @@ func (bt *BTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	return oldItem.(*Item).pos, true
 }
 
+func (bt *BTree) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	for i, op := range ops {
+		var oldItem btree.Item
+		if op.Pos == nil {
+			oldItem = bt.tree.Delete(&Item{key: op.Key})
+		} else {
+			oldItem = bt.tree.ReplaceOrInsert(&Item{key: op.Key, pos: op.Pos})
+		}
+		if oldItem != nil {
+			oldPositions[i] = oldItem.(*Item).pos
+		}
+	}
+	return oldPositions
+}
+
+// Clone 基于 btree 的写时复制，复制的代价很小
+func (bt *BTree) Clone() Indexer {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}
+}
+
 func (bt *BTree) Close() error {
 	return nil
 }
@@ -59,73 +88,139 @@ func (bt *BTree) Size() int {
 	return bt.tree.Len()
 }
 
+// 迭代器每次从树中取出的元素数量
+const btreeIteratorBatchSize = 256
+
+// btreeIterator 在树的写时复制的副本上按批次遍历，不会阻塞写入，也不需要复制全部的数据
 type btreeIterator struct {
-	currIndex int
-	reverse   bool    // 是否是反向的遍历
-	value     []*Item // key + 位置索引信息
+	tree    *btree.BTree // 创建迭代器时的副本，之后的写入不可见
+	reverse bool         // 是否是反向的遍历
+	lower   []byte       // 下界，包含
+	upper   []byte       // 上界，不包含
+	batch   []*Item      // 当前批次的 key + 位置索引信息
+	index   int
+	done    bool // 范围内已经没有更多的数据
 }
 
 func (bt *BTree) Iterator(reverse bool) Iterator {
+	return bt.RangeIterator(reverse, nil, nil)
+}
+
+func (bt *BTree) RangeIterator(reverse bool, lower, upper []byte) Iterator {
 	if bt.tree == nil {
 		return nil
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBtreeIterator(bt.tree, reverse)
-}
-func newBtreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
-	saveValues := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
-		return true
-	}
-	if reverse {
-		tree.Descend(saveValues)
-	} else {
-		tree.Ascend(saveValues)
-	}
-	return &btreeIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		value:     values,
+	// 复制需要修改原来的树的写时复制标记，需要持有写锁
+	bt.lock.Lock()
+	tree := bt.tree.Clone()
+	bt.lock.Unlock()
+	return newBtreeIterator(tree, reverse, lower, upper)
+}
+
+func newBtreeIterator(tree *btree.BTree, reverse bool, lower, upper []byte) *btreeIterator {
+	bti := &btreeIterator{
+		tree:    tree,
+		reverse: reverse,
+		lower:   lower,
+		upper:   upper,
+		batch:   make([]*Item, 0, btreeIteratorBatchSize),
 	}
+	bti.Rewind()
+	return bti
 }
+
 func (bti *btreeIterator) Rewind() {
-	bti.currIndex = 0
+	if bti.reverse {
+		bti.fill(bti.upper, false)
+	} else {
+		bti.fill(bti.lower, true)
+	}
 }
 
 // 根据key 查找第一个大于或者小于key的元素
 func (bti *btreeIterator) Seek(key []byte) {
 	if bti.reverse {
-		bti.currIndex = sort.Search(len(bti.value), func(i int) bool {
-			return bytes.Compare(bti.value[i].key, key) <= 0
-		})
+		if len(bti.upper) > 0 && bytes.Compare(key, bti.upper) >= 0 {
+			bti.fill(bti.upper, false)
+		} else {
+			bti.fill(key, true)
+		}
 	} else {
-		bti.currIndex = sort.Search(len(bti.value), func(i int) bool {
-			return bytes.Compare(bti.value[i].key, key) >= 0
-		})
+		if bytes.Compare(key, bti.lower) < 0 {
+			key = bti.lower
+		}
+		bti.fill(key, true)
 	}
 }
 
-// 跳转到下一个key
+// 跳转到下一个key，当前批次遍历完之后从最后一个 key 之后继续取
 func (bti *btreeIterator) Next() {
-	bti.currIndex += 1
+	bti.index++
+	if bti.index == len(bti.batch) && !bti.done {
+		bti.fill(bti.batch[len(bti.batch)-1].key, false)
+	}
+}
+
+// 从 start 开始取出一批数据，inclusive 表示是否包含 start，start 为空表示从头开始
+func (bti *btreeIterator) fill(start []byte, inclusive bool) {
+	bti.batch = bti.batch[:0]
+	bti.index = 0
+	bti.done = true
+	if bti.reverse {
+		saveValues := func(it btree.Item) bool {
+			item := it.(*Item)
+			if !inclusive && bytes.Equal(item.key, start) {
+				return true
+			}
+			if len(bti.lower) > 0 && bytes.Compare(item.key, bti.lower) < 0 {
+				return false
+			}
+			if len(bti.batch) == btreeIteratorBatchSize {
+				bti.done = false
+				return false
+			}
+			bti.batch = append(bti.batch, item)
+			return true
+		}
+		if len(start) > 0 {
+			bti.tree.DescendLessOrEqual(&Item{key: start}, saveValues)
+		} else {
+			bti.tree.Descend(saveValues)
+		}
+	} else {
+		saveValues := func(it btree.Item) bool {
+			item := it.(*Item)
+			if !inclusive && bytes.Equal(item.key, start) {
+				return true
+			}
+			// 上界不包含在范围内
+			if len(bti.upper) > 0 && bytes.Compare(item.key, bti.upper) >= 0 {
+				return false
+			}
+			if len(bti.batch) == btreeIteratorBatchSize {
+				bti.done = false
+				return false
+			}
+			bti.batch = append(bti.batch, item)
+			return true
+		}
+		bti.tree.AscendGreaterOrEqual(&Item{key: start}, saveValues)
+	}
 }
 
 func (bti *btreeIterator) Valid() bool {
-	return bti.currIndex < len(bti.value)
+	return bti.index < len(bti.batch)
 }
 
 func (bti *btreeIterator) Key() []byte {
-	return bti.value[bti.currIndex].key
+	return bti.batch[bti.index].key
 }
 
 func (bti *btreeIterator) Value() *data.LogRecordPos {
-	return bti.value[bti.currIndex].pos
+	return bti.batch[bti.index].pos
 }
 
 func (bti *btreeIterator) Close() {
-	bti.value = nil
+	bti.tree = nil
+	bti.batch = nil
 }
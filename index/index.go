@@ -3,19 +3,130 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"github.com/google/btree"
 )
 
+var ErrInvalidAppliedPosition = errors.New("invalid applied position in index")
+
 type Indexer interface {
 	Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos
 	Get(key []byte) *data.LogRecordPos
 	Delete(key []byte) (*data.LogRecordPos, bool)
+	// ApplyBatch 按顺序执行一组更新，返回每个 key 更新之前的位置，B+ 树索引在同一个事务中完成
+	ApplyBatch(ops []BatchOp) []*data.LogRecordPos
 
 	Size() int
 	Iterator(reverse bool) Iterator
+	// RangeIterator 返回只包含 [lower, upper) 范围内的 key 的迭代器，lower 或 upper 为空表示没有对应的边界
+	RangeIterator(reverse bool, lower, upper []byte) Iterator
+	// Clone 返回当前索引的一个只读副本，之后对原索引的修改不会影响副本
+	Clone() Indexer
 	Close() error
 }
 
+// BatchOp 批量更新索引中的一个操作，Pos 为 nil 表示删除 Key
+type BatchOp struct {
+	Key []byte
+	Pos *data.LogRecordPos
+}
+
+// PersistentIndexer 保存在磁盘上的索引，记录已经应用到索引中的数据文件位置
+// 索引的更新和位置的记录不在同一个事务中，位置只会落后于索引，重启时重放位置之后的数据即可
+type PersistentIndexer interface {
+	Indexer
+	AppliedPosition() (*AppliedPosition, error)
+	SetAppliedPosition(pos *AppliedPosition) error
+	// Reset 清空索引中的数据和记录的位置，重放全部的数据文件之前调用
+	Reset() error
+}
+
+// AppliedPosition 已经应用到索引中的数据文件位置，以及此时的事务序列号和每个数据文件的统计
+type AppliedPosition struct {
+	Fid       uint32
+	Offset    int64
+	SeqNo     uint64
+	DeadSizes map[uint32]int64 // 每个数据文件中可以回收的数据量
+	// 每个数据文件中 value 压缩前和实际占用的大小
+	ValueSizes           map[uint32]int64
+	CompressedValueSizes map[uint32]int64
+}
+
+func (pos *AppliedPosition) encode() []byte {
+	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*2)
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(pos.Fid))
+	index += binary.PutVarint(buf[index:], pos.Offset)
+	index += binary.PutUvarint(buf[index:], pos.SeqNo)
+	buf = buf[:index]
+	for _, sizes := range []map[uint32]int64{pos.DeadSizes, pos.ValueSizes, pos.CompressedValueSizes} {
+		buf = binary.AppendUvarint(buf, uint64(len(sizes)))
+		for fid, size := range sizes {
+			buf = binary.AppendUvarint(buf, uint64(fid))
+			buf = binary.AppendVarint(buf, size)
+		}
+	}
+	return buf
+}
+
+func decodeAppliedPosition(buf []byte) (*AppliedPosition, error) {
+	fid, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, ErrInvalidAppliedPosition
+	}
+	buf = buf[n:]
+	offset, n := binary.Varint(buf)
+	if n <= 0 {
+		return nil, ErrInvalidAppliedPosition
+	}
+	buf = buf[n:]
+	seqNo, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, ErrInvalidAppliedPosition
+	}
+	buf = buf[n:]
+	pos := &AppliedPosition{Fid: uint32(fid), Offset: offset, SeqNo: seqNo}
+	// 旧版本的索引中没有每个文件的统计，或者没有 value 的大小
+	for _, sizes := range []*map[uint32]int64{&pos.DeadSizes, &pos.ValueSizes, &pos.CompressedValueSizes} {
+		if len(buf) == 0 {
+			break
+		}
+		var err error
+		if *sizes, buf, err = decodeFileSizes(buf); err != nil {
+			return nil, err
+		}
+	}
+	return pos, nil
+}
+
+// 解码每个数据文件的统计，返回剩余的数据，没有统计时返回 nil
+func decodeFileSizes(buf []byte) (map[uint32]int64, []byte, error) {
+	num, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, nil, ErrInvalidAppliedPosition
+	}
+	buf = buf[n:]
+	var sizes map[uint32]int64
+	for i := uint64(0); i < num; i++ {
+		fid, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, nil, ErrInvalidAppliedPosition
+		}
+		buf = buf[n:]
+		size, n := binary.Varint(buf)
+		if n <= 0 {
+			return nil, nil, ErrInvalidAppliedPosition
+		}
+		buf = buf[n:]
+		if sizes == nil {
+			sizes = make(map[uint32]int64, num)
+		}
+		sizes[uint32(fid)] = size
+	}
+	return sizes, buf, nil
+}
+
 type IndexType = int8
 
 const (
@@ -39,6 +150,16 @@ func NewIndexer(typ IndexType, dirPath string, sync bool) Indexer {
 	}
 }
 
+// NewShardedIndexer shards 大于 1 时把内存索引分成多个分片，B+ 树索引存储在磁盘上，不支持分片
+func NewShardedIndexer(typ IndexType, dirPath string, sync bool, shards int) Indexer {
+	if shards <= 1 || typ == BPTree {
+		return NewIndexer(typ, dirPath, sync)
+	}
+	return NewShardedIndex(shards, func() Indexer {
+		return NewIndexer(typ, dirPath, sync)
+	})
+}
+
 type Item struct {
 	key []byte
 	pos *data.LogRecordPos
@@ -48,6 +169,14 @@ func (ai *Item) Less(bi btree.Item) bool {
 	return bytes.Compare(ai.key, bi.(*Item).key) == -1
 }
 
+// 判断 key 是否在 [lower, upper) 范围内
+func inRange(key, lower, upper []byte) bool {
+	if len(lower) > 0 && bytes.Compare(key, lower) < 0 {
+		return false
+	}
+	return len(upper) == 0 || bytes.Compare(key, upper) < 0
+}
+
 // 使用索引迭代器
 type Iterator interface {
 	Rewind()
@@ -0,0 +1,181 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestIndexer_RangeIterator(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bptree-range-iterator")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	bpt := NewBPlusTree(dir, false)
+	defer bpt.Close()
+
+	indexers := map[string]Indexer{
+		"btree":  NewBTree(),
+		"art":    NewART(),
+		"bptree": bpt,
+	}
+	for name, indexer := range indexers {
+		for _, key := range []string{"a", "ab", "abc", "b", "ba", "bb", "c"} {
+			indexer.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 10})
+		}
+
+		collect := func(iter Iterator) []string {
+			defer iter.Close()
+			var keys []string
+			for ; iter.Valid(); iter.Next() {
+				keys = append(keys, string(iter.Key()))
+			}
+			return keys
+		}
+
+		keys := collect(indexer.RangeIterator(false, []byte("ab"), []byte("bb")))
+		assert.Equal(t, []string{"ab", "abc", "b", "ba"}, keys, name)
+		keys = collect(indexer.RangeIterator(true, []byte("ab"), []byte("bb")))
+		assert.Equal(t, []string{"ba", "b", "abc", "ab"}, keys, name)
+		keys = collect(indexer.RangeIterator(false, nil, []byte("abc")))
+		assert.Equal(t, []string{"a", "ab"}, keys, name)
+		keys = collect(indexer.RangeIterator(true, []byte("bb"), nil))
+		assert.Equal(t, []string{"c", "bb"}, keys, name)
+		keys = collect(indexer.RangeIterator(false, []byte("d"), nil))
+		assert.Empty(t, keys, name)
+
+		// Seek 和 Rewind 不会超出范围
+		iter := indexer.RangeIterator(false, []byte("ab"), []byte("bb"))
+		iter.Seek([]byte("a"))
+		assert.Equal(t, "ab", string(iter.Key()), name)
+		iter.Seek([]byte("bb"))
+		assert.False(t, iter.Valid(), name)
+		iter.Rewind()
+		assert.Equal(t, "ab", string(iter.Key()), name)
+		iter.Close()
+
+		iter = indexer.RangeIterator(true, []byte("ab"), []byte("bb"))
+		iter.Seek([]byte("z"))
+		assert.Equal(t, "ba", string(iter.Key()), name)
+		iter.Seek([]byte("aa"))
+		assert.False(t, iter.Valid(), name)
+		iter.Close()
+	}
+}
+
+func TestIndexer_IteratorBatches(t *testing.T) {
+	indexers := map[string]Indexer{
+		"btree": NewBTree(),
+		"art":   NewART(),
+	}
+	for name, indexer := range indexers {
+		// 数据量超过一个批次
+		for i := 0; i < 1000; i++ {
+			indexer.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+
+		iter := indexer.Iterator(false)
+		var count int
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			assert.Equal(t, fmt.Sprintf("key-%04d", count), string(iter.Key()), name)
+			// 遍历的过程中在已经遍历过的位置修改树的结构
+			if count%100 == 0 {
+				indexer.Put([]byte(fmt.Sprintf("000-%04d", count)), &data.LogRecordPos{Fid: 1})
+				indexer.Delete([]byte(fmt.Sprintf("000-%04d", count-100)))
+			}
+			count++
+		}
+		assert.Equal(t, 1000, count, name)
+		iter.Seek([]byte("key-0500"))
+		assert.Equal(t, "key-0500", string(iter.Key()), name)
+		iter.Close()
+
+		iter = indexer.RangeIterator(true, []byte("key-0100"), []byte("key-0900"))
+		count = 0
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			assert.Equal(t, fmt.Sprintf("key-%04d", 899-count), string(iter.Key()), name)
+			count++
+		}
+		assert.Equal(t, 800, count, name)
+		iter.Close()
+	}
+
+	// 迭代器看不到创建之后的写入
+	for name, indexer := range map[string]Indexer{"btree": NewBTree(), "art": NewART()} {
+		for i := 0; i < 1000; i++ {
+			indexer.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+		for _, reverse := range []bool{false, true} {
+			iter := indexer.Iterator(reverse)
+			for i := 0; i < 1000; i += 2 {
+				indexer.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+				indexer.Put([]byte(fmt.Sprintf("key-%04d", i+1)), &data.LogRecordPos{Fid: 2})
+				indexer.Put([]byte(fmt.Sprintf("new-%04d", i)), &data.LogRecordPos{Fid: 2})
+			}
+			var count int
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				i := count
+				if reverse {
+					i = 999 - count
+				}
+				assert.Equal(t, fmt.Sprintf("key-%04d", i), string(iter.Key()), name)
+				assert.Equal(t, uint32(1), iter.Value().Fid, name)
+				count++
+			}
+			assert.Equal(t, 1000, count, name)
+			iter.Seek([]byte("key-0500"))
+			assert.Equal(t, "key-0500", string(iter.Key()), name)
+			iter.Close()
+
+			// 恢复原来的数据
+			for i := 0; i < 1000; i++ {
+				indexer.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+				indexer.Delete([]byte(fmt.Sprintf("new-%04d", i)))
+			}
+		}
+	}
+}
+
+func TestIndexer_ApplyBatch(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bptree-apply-batch")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	bpt := NewBPlusTree(dir, false)
+	defer bpt.Close()
+
+	indexers := map[string]Indexer{
+		"btree":   NewBTree(),
+		"art":     NewART(),
+		"bptree":  bpt,
+		"sharded": NewShardedIndex(4, func() Indexer { return NewBTree() }),
+	}
+	for name, indexer := range indexers {
+		indexer.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+		indexer.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+		// 同一批中对同一个 key 的操作按照顺序执行
+		oldPositions := indexer.ApplyBatch([]BatchOp{
+			{Key: []byte("a"), Pos: &data.LogRecordPos{Fid: 2, Offset: 10}},
+			{Key: []byte("b")},
+			{Key: []byte("c"), Pos: &data.LogRecordPos{Fid: 2, Offset: 20}},
+			{Key: []byte("c"), Pos: &data.LogRecordPos{Fid: 2, Offset: 30}},
+			{Key: []byte("d")},
+		})
+		assert.Equal(t, 5, len(oldPositions), name)
+		assert.Equal(t, int64(10), oldPositions[0].Offset, name)
+		assert.Equal(t, int64(20), oldPositions[1].Offset, name)
+		assert.Nil(t, oldPositions[2], name)
+		assert.Equal(t, int64(20), oldPositions[3].Offset, name)
+		assert.Nil(t, oldPositions[4], name)
+
+		assert.Equal(t, 2, indexer.Size(), name)
+		assert.Equal(t, uint32(2), indexer.Get([]byte("a")).Fid, name)
+		assert.Nil(t, indexer.Get([]byte("b")), name)
+		assert.Equal(t, int64(30), indexer.Get([]byte("c")).Offset, name)
+
+		assert.Equal(t, 0, len(indexer.ApplyBatch(nil)), name)
+	}
+}
@@ -0,0 +1,225 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"container/heap"
+	"hash/fnv"
+	"sync"
+)
+
+// ShardedIndex 按照 key 的哈希值把数据分到多个独立的索引中，每个索引有自己的锁
+// 有序遍历时对所有分片的迭代器做多路归并
+// 修改时持有 mu 的读锁，创建迭代器和克隆时持有写锁，所有分片的快照是同一时刻的，不会只包含一批更新中的一部分
+// 读取单个 key 只需要分片自己的锁
+type ShardedIndex struct {
+	shards []Indexer
+	mu     *sync.RWMutex
+}
+
+func NewShardedIndex(shards int, newShard func() Indexer) *ShardedIndex {
+	si := &ShardedIndex{shards: make([]Indexer, shards), mu: new(sync.RWMutex)}
+	for i := range si.shards {
+		si.shards[i] = newShard()
+	}
+	return si
+}
+
+func (si *ShardedIndex) shard(key []byte) Indexer {
+	return si.shards[si.shardIndex(key)]
+}
+
+func (si *ShardedIndex) shardIndex(key []byte) int {
+	h := fnv.New32a()
+	_, _ = h.Write(key)
+	return int(h.Sum32() % uint32(len(si.shards)))
+}
+
+func (si *ShardedIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return si.shard(key).Put(key, pos)
+}
+
+func (si *ShardedIndex) Get(key []byte) *data.LogRecordPos {
+	return si.shard(key).Get(key)
+}
+
+func (si *ShardedIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	return si.shard(key).Delete(key)
+}
+
+// ApplyBatch 按照分片拆分之后分别执行，同一个 key 的操作在同一个分片中，顺序不变
+func (si *ShardedIndex) ApplyBatch(ops []BatchOp) []*data.LogRecordPos {
+	shardOps := make([][]BatchOp, len(si.shards))
+	shardIdx := make([][]int, len(si.shards))
+	for i, op := range ops {
+		n := si.shardIndex(op.Key)
+		shardOps[n] = append(shardOps[n], op)
+		shardIdx[n] = append(shardIdx[n], i)
+	}
+	oldPositions := make([]*data.LogRecordPos, len(ops))
+	si.mu.RLock()
+	defer si.mu.RUnlock()
+	for n, shard := range si.shards {
+		if len(shardOps[n]) == 0 {
+			continue
+		}
+		for i, oldPos := range shard.ApplyBatch(shardOps[n]) {
+			oldPositions[shardIdx[n][i]] = oldPos
+		}
+	}
+	return oldPositions
+}
+
+func (si *ShardedIndex) Size() int {
+	var size int
+	for _, shard := range si.shards {
+		size += shard.Size()
+	}
+	return size
+}
+
+func (si *ShardedIndex) Iterator(reverse bool) Iterator {
+	return si.RangeIterator(reverse, nil, nil)
+}
+
+func (si *ShardedIndex) RangeIterator(reverse bool, lower, upper []byte) Iterator {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	iters := make([]Iterator, len(si.shards))
+	for i, shard := range si.shards {
+		iters[i] = shard.RangeIterator(reverse, lower, upper)
+	}
+	return newMergeIterator(iters, reverse)
+}
+
+func (si *ShardedIndex) Clone() Indexer {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	shards := make([]Indexer, len(si.shards))
+	for i, shard := range si.shards {
+		shards[i] = shard.Clone()
+	}
+	return &ShardedIndex{shards: shards, mu: new(sync.RWMutex)}
+}
+
+func (si *ShardedIndex) Close() error {
+	for _, shard := range si.shards {
+		if err := shard.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeIterator 对多个有序的迭代器做多路归并，各个迭代器中的 key 不能重复
+type mergeIterator struct {
+	iters   []Iterator
+	heap    iteratorHeap // 还有数据的迭代器，堆顶是当前的 key
+	reverse bool
+}
+
+func newMergeIterator(iters []Iterator, reverse bool) *mergeIterator {
+	mi := &mergeIterator{
+		iters:   iters,
+		heap:    iteratorHeap{reverse: reverse},
+		reverse: reverse,
+	}
+	mi.rebuild()
+	return mi
+}
+
+// 子迭代器重新定位之后重建堆
+func (mi *mergeIterator) rebuild() {
+	mi.heap.iters = mi.heap.iters[:0]
+	for _, iter := range mi.iters {
+		if iter.Valid() {
+			mi.heap.iters = append(mi.heap.iters, iter)
+		}
+	}
+	heap.Init(&mi.heap)
+}
+
+func (mi *mergeIterator) Rewind() {
+	for _, iter := range mi.iters {
+		iter.Rewind()
+	}
+	mi.rebuild()
+}
+
+// 根据key 查找第一个大于或者小于key的元素
+func (mi *mergeIterator) Seek(key []byte) {
+	for _, iter := range mi.iters {
+		iter.Seek(key)
+	}
+	mi.rebuild()
+}
+
+// 跳转到下一个key
+func (mi *mergeIterator) Next() {
+	if !mi.Valid() {
+		return
+	}
+	top := mi.heap.iters[0]
+	top.Next()
+	if top.Valid() {
+		heap.Fix(&mi.heap, 0)
+	} else {
+		heap.Pop(&mi.heap)
+	}
+}
+
+func (mi *mergeIterator) Valid() bool {
+	return len(mi.heap.iters) > 0
+}
+
+func (mi *mergeIterator) Key() []byte {
+	return mi.heap.iters[0].Key()
+}
+
+func (mi *mergeIterator) Value() *data.LogRecordPos {
+	return mi.heap.iters[0].Value()
+}
+
+func (mi *mergeIterator) Close() {
+	for _, iter := range mi.iters {
+		iter.Close()
+	}
+	mi.heap.iters = nil
+}
+
+// 按照当前 key 排序的迭代器堆，反向遍历时是大顶堆
+type iteratorHeap struct {
+	iters   []Iterator
+	reverse bool
+}
+
+func (h *iteratorHeap) Len() int {
+	return len(h.iters)
+}
+
+func (h *iteratorHeap) Less(i, j int) bool {
+	cmp := bytes.Compare(h.iters[i].Key(), h.iters[j].Key())
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *iteratorHeap) Swap(i, j int) {
+	h.iters[i], h.iters[j] = h.iters[j], h.iters[i]
+}
+
+func (h *iteratorHeap) Push(x any) {
+	h.iters = append(h.iters, x.(Iterator))
+}
+
+func (h *iteratorHeap) Pop() any {
+	n := len(h.iters)
+	iter := h.iters[n-1]
+	h.iters = h.iters[:n-1]
+	return iter
+}
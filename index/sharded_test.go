@@ -0,0 +1,95 @@
+package index
+
+import (
+	"bitcask-go/data"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestShardedIndex(t *testing.T) {
+	si := NewShardedIndex(8, func() Indexer {
+		return NewBTree()
+	})
+	for i := 0; i < 1000; i++ {
+		res := si.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		assert.Nil(t, res)
+	}
+	assert.Equal(t, 1000, si.Size())
+	pos := si.Get([]byte("key-0010"))
+	assert.Equal(t, int64(10), pos.Offset)
+	oldPos, ok := si.Delete([]byte("key-0010"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), oldPos.Offset)
+	assert.Nil(t, si.Get([]byte("key-0010")))
+
+	// 多个分片归并之后仍然有序
+	iter := si.Iterator(false)
+	var prev []byte
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.True(t, prev == nil || string(prev) < string(iter.Key()))
+		prev = iter.Key()
+		count++
+	}
+	assert.Equal(t, 999, count)
+	iter.Seek([]byte("key-0500"))
+	assert.Equal(t, "key-0500", string(iter.Key()))
+	iter.Close()
+
+	iter = si.RangeIterator(true, []byte("key-0005"), []byte("key-0012"))
+	var keys []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"key-0011", "key-0009", "key-0008", "key-0007", "key-0006", "key-0005"}, keys)
+	iter.Seek([]byte("key-0008"))
+	assert.Equal(t, "key-0008", string(iter.Key()))
+	iter.Close()
+
+	clone := si.Clone()
+	si.Put([]byte("new"), &data.LogRecordPos{Fid: 1})
+	assert.Nil(t, clone.Get([]byte("new")))
+	assert.Equal(t, 999, clone.Size())
+}
+
+func TestShardedIndex_ConsistentIterator(t *testing.T) {
+	si := NewShardedIndex(8, func() Indexer {
+		return NewBTree()
+	})
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key-%04d", i))
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for v := int64(1); v <= 200; v++ {
+			ops := make([]BatchOp, len(keys))
+			for i, key := range keys {
+				ops[i] = BatchOp{Key: key, Pos: &data.LogRecordPos{Fid: 1, Offset: v}}
+			}
+			si.ApplyBatch(ops)
+		}
+	}()
+
+	// 迭代器中要么包含一批更新中的全部 key，要么都不包含
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		iter := si.Iterator(false)
+		var offset int64 = -1
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			if offset == -1 {
+				offset = iter.Value().Offset
+			}
+			if !assert.Equal(t, offset, iter.Value().Offset) {
+				break
+			}
+		}
+		iter.Close()
+	}
+}
@@ -1,6 +1,7 @@
 package bitcask_go
 
 import (
+	"bitcask-go/data"
 	"bitcask-go/index"
 	"bytes"
 )
@@ -9,11 +10,14 @@ import (
 type Iterator struct {
 	indexIter index.Iterator
 	db        *DB
+	snapshot  *Snapshot // 在快照上创建的迭代器从快照持有的数据文件中读取
 	option    IteratorOptions
+	count     int // 已经返回的 key 的数量
 }
 
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
+	lower, upper := iteratorBounds(opts)
+	indexIter := db.index.RangeIterator(opts.Reverse, lower, upper)
 	return &Iterator{
 		db:        db,
 		indexIter: indexIter,
@@ -22,24 +26,48 @@ func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
 
 }
 
+// 合并前缀和上下界，得到索引迭代器的遍历范围
+func iteratorBounds(opts IteratorOptions) (lower, upper []byte) {
+	lower, upper = opts.LowerBound, opts.UpperBound
+	if len(opts.Prefix) == 0 {
+		return lower, upper
+	}
+	if bytes.Compare(opts.Prefix, lower) > 0 {
+		lower = opts.Prefix
+	}
+	// prefix 全部是 0xff 时没有上界
+	if end := prefixUpperBound(opts.Prefix); end != nil {
+		if len(upper) == 0 || bytes.Compare(end, upper) < 0 {
+			upper = end
+		}
+	}
+	return lower, upper
+}
+
 func (iter *Iterator) Rewind() {
+	iter.count = 0
 	iter.indexIter.Rewind()
 	iter.skipToNext()
 }
 
 // 根据key 查找第一个大于或者小于key的元素
 func (iter *Iterator) Seek(key []byte) {
+	iter.count = 0
 	iter.indexIter.Seek(key)
 	iter.skipToNext()
 }
 
 // 跳转到下一个key
 func (iter *Iterator) Next() {
+	iter.count++
 	iter.indexIter.Next()
 	iter.skipToNext()
 }
 
 func (iter *Iterator) Valid() bool {
+	if iter.option.Limit > 0 && iter.count >= iter.option.Limit {
+		return false
+	}
 	return iter.indexIter.Valid()
 }
 
@@ -48,25 +76,71 @@ func (iter *Iterator) Key() []byte {
 }
 
 func (iter *Iterator) Value() ([]byte, error) {
+	if iter.option.KeysOnly {
+		return nil, ErrKeysOnlyIterator
+	}
 	logRecordPos := iter.indexIter.Value()
+	if iter.snapshot != nil {
+		iter.snapshot.mu.RLock()
+		defer iter.snapshot.mu.RUnlock()
+		if iter.snapshot.released {
+			return nil, ErrSnapshotReleased
+		}
+		return iter.snapshot.getValueByPosition(logRecordPos)
+	}
 	iter.db.mu.RLock()
 	defer iter.db.mu.RUnlock()
-	return iter.db.getValueByPosition(logRecordPos)
+	return iter.db.getIteratorValue(iter.Key(), logRecordPos)
+}
+
+// 迭代器中的位置是创建时的，之后数据文件可能被 Compact 替换，位置上的记录不一定还是这个 key
+// 这时根据当前的索引重新查找，需要持有 db.mu
+func (db *DB) getIteratorValue(key []byte, logRecordPos *data.LogRecordPos) ([]byte, error) {
+	var dataFile *data.DataFile
+	if db.activeFile.FileId == logRecordPos.Fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[logRecordPos.Fid]
+	}
+	if dataFile != nil {
+		logRecord, size, err := dataFile.ReadLogRecord(logRecordPos.Offset)
+		if err == nil && logRecord.Type == data.LogRecordNormal &&
+			(logRecordPos.Size == 0 || int64(logRecordPos.Size) == size) {
+			if realKey, _ := parseLogRecordKey(logRecord.Key); bytes.Equal(realKey, key) {
+				if logRecord.IsExpired() {
+					return nil, ErrKeyNotFound
+				}
+				return logRecord.Value, nil
+			}
+		}
+	}
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	if isSamePos(pos, logRecordPos) {
+		return readValueFromFile(dataFile, pos)
+	}
+	return db.getValueByPosition(pos)
 }
 
 func (iter *Iterator) Close() {
 	iter.indexIter.Close()
 }
 
+// 范围已经由索引迭代器保证，这里只需要跳过已经过期的 key
 func (iter *Iterator) skipToNext() {
-	prefixLen := len(iter.option.Prefix)
-	if prefixLen == 0 {
-		return
-	}
 	for ; iter.indexIter.Valid(); iter.indexIter.Next() {
-		key := iter.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(iter.option.Prefix, key[:prefixLen]) == 0 {
+		if !iter.indexIter.Value().IsExpired() {
 			break
 		}
 	}
 }
+
+// key 是否在 [lower, upper) 范围内，边界为空表示没有限制
+func inBounds(key, lower, upper []byte) bool {
+	if len(lower) > 0 && bytes.Compare(key, lower) < 0 {
+		return false
+	}
+	return len(upper) == 0 || bytes.Compare(key, upper) < 0
+}
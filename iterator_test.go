@@ -86,3 +86,64 @@ func TestDB_NewIterator_Multi_Value(t *testing.T) {
 		t.Log("key=", string(iter3.Key()))
 	}
 }
+
+func TestDB_NewIterator_Range(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-iterator-range")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	for _, key := range []string{"a", "ab", "abc", "b", "ba", "bb", "c"} {
+		err := db.Put([]byte(key), []byte("value-"+key))
+		assert.Nil(t, err)
+	}
+
+	collect := func(opts IteratorOptions) []string {
+		iter := db.NewIterator(opts)
+		defer iter.Close()
+		var keys []string
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+	keys := collect(IteratorOptions{LowerBound: []byte("ab"), UpperBound: []byte("bb")})
+	assert.Equal(t, []string{"ab", "abc", "b", "ba"}, keys)
+	keys = collect(IteratorOptions{LowerBound: []byte("ab"), UpperBound: []byte("bb"), Reverse: true, Limit: 2})
+	assert.Equal(t, []string{"ba", "b"}, keys)
+	// 前缀和上下界同时生效
+	keys = collect(IteratorOptions{Prefix: []byte("a"), LowerBound: []byte("ab")})
+	assert.Equal(t, []string{"ab", "abc"}, keys)
+	keys = collect(IteratorOptions{Prefix: []byte("b"), UpperBound: []byte("bb")})
+	assert.Equal(t, []string{"b", "ba"}, keys)
+
+	// 分页：Seek 之后重新计数
+	iter := db.NewIterator(IteratorOptions{Limit: 2, KeysOnly: true})
+	defer iter.Close()
+	keys = nil
+	for iter.Seek([]byte("abc")); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"abc", "b"}, keys)
+	iter.Seek([]byte("c"))
+	assert.True(t, iter.Valid())
+	_, err = iter.Value()
+	assert.Equal(t, ErrKeysOnlyIterator, err)
+
+	// 事务中的写入也受范围和数量限制
+	txn := db.Begin()
+	defer txn.Rollback()
+	assert.Nil(t, txn.Put([]byte("aa"), []byte("value-aa")))
+	assert.Nil(t, txn.Delete([]byte("abc")))
+	assert.Nil(t, txn.Put([]byte("bc"), []byte("value-bc")))
+	keys = nil
+	err = txn.Iterate(IteratorOptions{LowerBound: []byte("aa"), UpperBound: []byte("bc"), Limit: 3, KeysOnly: true},
+		func(key []byte, value []byte) bool {
+			assert.Nil(t, value)
+			keys = append(keys, string(key))
+			return true
+		})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"aa", "ab", "b"}, keys)
+}
@@ -0,0 +1,97 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"log"
+	"sync"
+)
+
+// 启动时从一个旧的数据文件中读取到的记录
+type loadedDataFile struct {
+	entries  []*hintEntry
+	fromHint bool // 是否是从 hint 文件中读取的
+	// 文件中 value 压缩前和实际占用的大小
+	valueSize           int64
+	compressedValueSize int64
+	err                 error
+}
+
+// 并行读取旧的数据文件，按照文件 id 的顺序把读取的结果交给 fn
+// 同一时间最多有 workers 个文件的结果保存在内存中，fn 返回错误时停止读取
+func (db *DB) loadDataFilesParallel(dataFiles []*data.DataFile, workers int,
+	fn func(dataFile *data.DataFile, loaded *loadedDataFile) error) error {
+	workers = max(workers, 1)
+	results := make([]chan *loadedDataFile, len(dataFiles))
+	for i := range results {
+		results[i] = make(chan *loadedDataFile, 1)
+	}
+	// 结果被 fn 处理之后才释放名额，避免读取的速度比更新索引快时占用过多的内存
+	slots := make(chan struct{}, workers)
+	done := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	defer func() {
+		close(done)
+		wg.Wait()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, dataFile := range dataFiles {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			wg.Add(1)
+			go func(i int, dataFile *data.DataFile) {
+				defer wg.Done()
+				results[i] <- db.readDataFileEntries(dataFile)
+			}(i, dataFile)
+		}
+	}()
+
+	for i, dataFile := range dataFiles {
+		loaded := <-results[i]
+		<-slots
+		if loaded.err != nil {
+			return loaded.err
+		}
+		if err := fn(dataFile, loaded); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 读取旧的数据文件中构建索引需要的记录，优先从 hint 文件中读取，hint 文件不可用时再遍历数据文件
+func (db *DB) readDataFileEntries(dataFile *data.DataFile) *loadedDataFile {
+	loaded, ok, err := db.readDataHintFile(dataFile)
+	if err != nil {
+		log.Printf("failed to read hint file of data file %d, load from data file: %v", dataFile.FileId, err)
+	}
+	if ok && err == nil {
+		return loaded
+	}
+
+	loaded = &loadedDataFile{}
+	_, err = db.scanDataFile(dataFile, false, func(logRecord *data.LogRecord, offset, size int64) error {
+		loaded.valueSize += int64(len(logRecord.Value))
+		loaded.compressedValueSize += logRecord.StoredValueSize()
+		// 只保留构建索引需要的部分，范围删除的 value 是范围的结束位置
+		record := &data.LogRecord{
+			Key:    append([]byte(nil), logRecord.Key...),
+			Type:   logRecord.Type,
+			Expire: logRecord.Expire,
+		}
+		if logRecord.Type == data.LogRecordRangeDelete {
+			record.Value = logRecord.Value
+		}
+		loaded.entries = append(loaded.entries, &hintEntry{logRecord: record, offset: offset, size: size})
+		return nil
+	})
+	if err != nil {
+		return &loadedDataFile{err: err}
+	}
+	return loaded
+}
@@ -0,0 +1,84 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_ParallelIndexLoad(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-parallel-load")
+	opts.DirPath = dir
+	opts.DataFileSize = 16 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	// 同一个 key 在多个文件中被覆盖和删除，事务的记录也会跨越多个文件
+	expected := make(map[string][]byte)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 200; i++ {
+			value := utils.RandomValue(32)
+			err := db.Put(utils.GetTestKey(i), value)
+			assert.Nil(t, err)
+			expected[string(utils.GetTestKey(i))] = value
+		}
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		for i := 200; i < 400; i++ {
+			value := utils.RandomValue(32)
+			assert.Nil(t, wb.Put(utils.GetTestKey(i), value))
+			expected[string(utils.GetTestKey(i))] = value
+		}
+		assert.Nil(t, wb.Commit())
+		for i := round * 10; i < round*10+5; i++ {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			delete(expected, string(utils.GetTestKey(i)))
+		}
+	}
+	start, end := utils.GetTestKey(100), utils.GetTestKey(120)
+	err = db.DeleteRange(start, end)
+	assert.Nil(t, err)
+	for key := range expected {
+		if key >= string(start) && key < string(end) {
+			delete(expected, key)
+		}
+	}
+	fileNum := db.Stat().DataFileNum
+	assert.True(t, fileNum > 5)
+	for fid := uint32(0); fid < uint32(fileNum)-1; fid++ {
+		waitDataHintFile(t, dir, fid)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 一部分文件从 hint 文件中加载，另一部分遍历数据文件
+	for fid := uint32(0); fid < uint32(fileNum)-1; fid += 2 {
+		assert.Nil(t, os.Remove(data.GetDataHintFileName(dir, fid)))
+	}
+
+	for _, workers := range []int{0, 1, 3, 16} {
+		opts.IndexLoadWorkers = workers
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, len(expected), len(db.ListKeys()))
+		for key, value := range expected {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		// 事务序列号在重启之后继续递增
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Put(utils.GetTestKey(1000+workers), []byte("value")))
+		assert.Nil(t, wb.Commit())
+		expected[string(utils.GetTestKey(1000+workers))] = []byte("value")
+		err = db.Close()
+		assert.Nil(t, err)
+	}
+
+	opts.IndexLoadWorkers = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
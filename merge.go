@@ -2,59 +2,105 @@ package bitcask_go
 
 import (
 	"bitcask-go/data"
+	"bitcask-go/index"
 	"bitcask-go/utils"
-	"errors"
+	"context"
 	"io"
 	"os"
 	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 const mergeDirName = "-merge"
 const mergeFinishedKey = "merge.finished"
 
+// merge 的内部参数
+type mergeConfig struct {
+	ctx        context.Context
+	checkRatio bool                         // 是否检查 DataFileMergeRatio
+	limiter    *utils.RateLimiter           // 读取数据文件的限速器，为 nil 表示不限速
+	progress   func(progress MergeProgress) // 进度回调，为 nil 表示不需要
+}
+
+// MergeProgress merge 的进度，字节为单位
+type MergeProgress struct {
+	TotalFiles    int   // 需要 merge 的数据文件数量
+	FilesDone     int   // 已经处理完的数据文件数量
+	BytesRead     int64 // 已经读取的数据量
+	BytesWritten  int64 // 已经写入 merge 目录的数据量
+	RecordsCopied int64 // 已经重写的有效记录数量
+}
+
 func (db *DB) Merge() error {
+	return db.MergeWithContext(context.Background(), DefaultMergeOptions)
+}
+
+// MergeWithContext 和 Merge 相同，可以限速、获取进度，ctx 被取消时停止 merge 并返回 ctx 的错误
+// 取消之后 merge 目录会被删除，数据库不受影响，之后可以重新 merge
+func (db *DB) MergeWithContext(ctx context.Context, opts MergeOptions) error {
+	return db.merge(mergeConfig{
+		ctx:        ctx,
+		checkRatio: true,
+		limiter:    utils.NewRateLimiter(opts.BytesPerSecond),
+		progress:   opts.Progress,
+	})
+}
+
+func (db *DB) merge(cfg mergeConfig) (err error) {
+	if cfg.ctx == nil {
+		cfg.ctx = context.Background()
+	}
 	if db.activeFile == nil {
 		return nil
 	}
+	// 正在更新索引的组提交写入的数据可能在要 merge 的文件中，需要等它完成再选择文件
+	resume := db.pauseCommit()
 	db.mu.Lock()
-	if db.isMerging {
+	unlock := func() {
 		db.mu.Unlock()
-		return errors.New("merge is process, try again")
+		resume()
+	}
+	if db.isMerging {
+		unlock()
+		return ErrMergeIsProgress
 	}
 	// 查看数据量是否到达阈值
 	totalSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
-		db.mu.Unlock()
+		unlock()
 		return err
 	}
-	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
-		db.mu.Unlock()
+	if cfg.checkRatio && float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
+		unlock()
 		return ErrMergeRatioUnreached
 	}
 	availableDiskSize, err := utils.AvailableDiskSize()
 	if err != nil {
-		db.mu.Unlock()
+		unlock()
 		return err
 	}
 	if uint64(totalSize-db.reclaimSize) >= availableDiskSize {
+		unlock()
 		return ErrNotEnoughSpaceForMerge
 	}
 	db.isMerging = true
 	defer func() {
+		db.mu.Lock()
 		db.isMerging = false
+		db.mu.Unlock()
 	}()
+	reclaimSize := db.reclaimSize
 
 	// 0 1 2
-	if err := db.activeFile.Sync(); err != nil {
-		db.mu.Unlock()
+	if err := db.sealActiveFile(); err != nil {
+		unlock()
 		return err
 	}
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
 	if err := db.setActiveDataFile(); err != nil {
-		db.mu.Unlock()
+		unlock()
 		return nil
 	}
 
@@ -64,7 +110,7 @@ func (db *DB) Merge() error {
 	for _, file := range db.olderFiles {
 		mergeFiles = append(mergeFiles, file)
 	}
-	db.mu.Unlock()
+	unlock()
 
 	// 将merge的文件 从小到大 依次排序 依次merge
 	sort.Slice(mergeFiles, func(i, j int) bool {
@@ -84,32 +130,48 @@ func (db *DB) Merge() error {
 	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
 		return err
 	}
+	// 没有完成的 merge 目录不会被使用，直接删除
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(mergePath)
+		}
+	}()
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrite = false
+	mergeOptions.AutoMerge.Enable = false
+	// merge 时不更新索引，检查点中的索引是空的，不能写进 merge 目录
+	mergeOptions.IndexCheckpointInterval = 0
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		_ = mergeDB.Close()
+	}()
 	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
+	progress := MergeProgress{TotalFiles: len(mergeFiles)}
 	for _, dataFile := range mergeFiles {
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
+		_, err := db.scanDataFile(dataFile, false, func(logRecord *data.LogRecord, offset, size int64) error {
+			if err := cfg.limiter.WaitContext(cfg.ctx, size); err != nil {
 				return err
 			}
+			progress.BytesRead += size
+			// 范围删除之前的数据都已经不在索引中了，不需要保留
+			if logRecord.Type == data.LogRecordRangeDelete {
+				return nil
+			}
 			realKey, _ := parseLogRecordKey(logRecord.Key)
 			logRecordPos := db.index.Get(realKey)
+			// 只重写索引中仍然有效并且没有过期的数据
 			if logRecordPos != nil &&
-				logRecordPos.Fid != dataFile.FileId &&
-				logRecordPos.Offset == offset {
+				logRecordPos.Fid == dataFile.FileId &&
+				logRecordPos.Offset == offset &&
+				!logRecordPos.IsExpired() {
 				// 清除事务标记
 				logRecord.Key = logRecordKeyWithSeq(realKey, NonTransitionSeqNo)
 				pos, err := mergeDB.AppendLogRecord(logRecord)
@@ -120,12 +182,31 @@ func (db *DB) Merge() error {
 				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
 					return err
 				}
+				progress.BytesWritten += int64(pos.Size)
+				progress.RecordsCopied++
 			}
-
-			// 增加offset
-			offset += size
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		progress.FilesDone++
+		if cfg.progress != nil {
+			cfg.progress(progress)
 		}
 	}
+	// 最后写入 merge 之后每个数据文件中 value 的大小，启动时这些文件只从 hint 索引文件中加载
+	footer := &data.LogRecord{
+		Value: appendFileSizes(appendFileSizes(nil, mergeDB.valueSizes), mergeDB.compressedValueSizes),
+		Type:  data.LogRecordHintFooter,
+	}
+	encFooter, _, err := data.EncodeLogRecordWithCipher(footer, db.cipher)
+	if err != nil {
+		return err
+	}
+	if err := hintFile.Write(encFooter); err != nil {
+		return err
+	}
 	// sync 保证持久化
 	if err := hintFile.Sync(); err != nil {
 		return err
@@ -151,6 +232,9 @@ func (db *DB) Merge() error {
 	if err := mergeFinishedFile.Sync(); err != nil {
 		return err
 	}
+	db.mu.Lock()
+	db.mergedReclaimSize = reclaimSize
+	db.mu.Unlock()
 	return nil
 }
 
@@ -186,6 +270,18 @@ func (db *DB) loadMergeFiles() error {
 		if entry.Name() == fileLockName {
 			continue
 		}
+		// 没有写完的 hint 临时文件
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		// B+ 树索引文件属于 merge 目录自己的索引，不能覆盖原目录的索引
+		if entry.Name() == index.BptreeIndexFileName {
+			continue
+		}
+		if entry.Name() == data.IndexCheckpointFileName {
+			continue
+		}
+		mergeFileNames = append(mergeFileNames, entry.Name())
 	}
 	if !mergeFinished {
 		return nil
@@ -195,13 +291,28 @@ func (db *DB) loadMergeFiles() error {
 	if err != nil {
 		return err
 	}
+	// 索引检查点和 B+ 树索引中记录的位置指向旧的数据文件，不能再使用
+	checkpointFileName := filepath.Join(db.options.DirPath, data.IndexCheckpointFileName)
+	if err := os.RemoveAll(checkpointFileName); err != nil {
+		return err
+	}
+	if indexer, ok := db.index.(index.PersistentIndexer); ok {
+		if err := indexer.SetAppliedPosition(nil); err != nil {
+			return err
+		}
+	}
 	// 删除 旧的数据文件
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.RemoveAll(fileName); err != nil {
-				return err
+		fileNames := []string{
+			data.GetDataFileName(db.options.DirPath, fileId),
+			data.GetDataHintFileName(db.options.DirPath, fileId),
+		}
+		for _, fileName := range fileNames {
+			if _, err := os.Stat(fileName); err == nil {
+				if err := os.RemoveAll(fileName); err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -247,8 +358,10 @@ func (db *DB) loadIndexFromHintFile() error {
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
 
-	// 读取文件中的索引
+	// 读取文件中的索引，分批更新到索引中
+	var ops []index.BatchOp
 	var offset int64 = 0
 	for {
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
@@ -258,12 +371,33 @@ func (db *DB) loadIndexFromHintFile() error {
 			}
 			return err
 		}
+		offset += size
+		if logRecord.Type == data.LogRecordHintFooter {
+			valueSizes, rest, err := decodeFileSizes(logRecord.Value)
+			if err != nil {
+				return err
+			}
+			compressedValueSizes, _, err := decodeFileSizes(rest)
+			if err != nil {
+				return err
+			}
+			db.restoreValueSizes(valueSizes, compressedValueSizes)
+			continue
+		}
 		// 解码之后拿到实际的位置索引
 
 		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
-		offset += size
+		// merge 之后才过期的数据不再加载
+		if pos.IsExpired() {
+			db.addDeadSize(pos)
+		} else {
+			ops = append(ops, index.BatchOp{Key: logRecord.Key, Pos: pos})
+		}
+		if len(ops) >= indexLoadBatchSize {
+			db.index.ApplyBatch(ops)
+			ops = ops[:0]
+		}
 	}
-
+	db.index.ApplyBatch(ops)
 	return nil
 }
@@ -0,0 +1,116 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 50000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 10000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 40000; i < 50000; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), time.Millisecond*100)
+		assert.Nil(t, err)
+	}
+	time.Sleep(time.Millisecond * 150)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 重启之后 merge 的结果生效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.Equal(t, 30000, len(keys))
+	for i := 10000; i < 40000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(45000))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_MergeWithContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-ctx")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+
+	// 限速之后在超时之前无法完成，取消之后 merge 目录被删除
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	err = db.MergeWithContext(ctx, MergeOptions{BytesPerSecond: 64 * 1024})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 已经取消的 ctx
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = db.MergeWithContext(ctx, DefaultMergeOptions)
+	assert.Equal(t, context.Canceled, err)
+
+	var progresses []MergeProgress
+	err = db.MergeWithContext(context.Background(), MergeOptions{
+		Progress: func(progress MergeProgress) {
+			progresses = append(progresses, progress)
+		},
+	})
+	assert.Nil(t, err)
+	assert.True(t, len(progresses) > 1)
+	last := progresses[len(progresses)-1]
+	assert.Equal(t, last.TotalFiles, last.FilesDone)
+	assert.Equal(t, len(progresses), last.FilesDone)
+	assert.Equal(t, int64(1000), last.RecordsCopied)
+	assert.True(t, last.BytesWritten > 0)
+	assert.True(t, last.BytesRead > last.BytesWritten)
+
+	// 取消不影响之后的 merge 和数据
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+	for i := 1000; i < 2000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
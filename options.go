@@ -1,6 +1,11 @@
 package bitcask_go
 
-import "os"
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"os"
+	"time"
+)
 
 type Options struct {
 	DirPath      string
@@ -9,16 +14,85 @@ type Options struct {
 	// 累计写到多少持久化
 	BytePerSync uint
 	IndexType   IndexerType
+	// 内存索引的分片数量，按照 key 的哈希值分片，减少索引锁的竞争，小于等于 1 表示不分片，B+ 树索引不支持分片
+	// 组提交写入之后释放 db.mu 再更新索引，读取只需要等待 key 所在分片的锁
+	IndexShards int
+	// 启动时并行读取数据文件和 hint 文件的协程数量，小于等于 1 表示依次读取
+	IndexLoadWorkers int
+	// 定时把内存索引保存到检查点文件的时间间隔，重启时只需要重放检查点之后写入的数据，为 0 表示不定时保存
+	IndexCheckpointInterval time.Duration
 	// 启动时是否加载mmap
 	MMapAtStartup bool
+	// 读写数据文件使用的 IO 类型
+	IOType IOType
 
 	// 数据文件合并的阈值
 	DataFileMergeRatio float32
+
+	// 后台自动 merge 的配置
+	AutoMerge AutoMergeOptions
+
+	// 数据文件中的记录损坏时的处理方式，活跃文件末尾写了一半的记录总是会被截断
+	RecoveryMode RecoveryMode
+
+	// 写入 value 时使用的压缩算法，修改之后旧的数据仍然可以读取，merge 时会使用新的算法重写
+	Compression CompressionType
+
+	// 加密数据文件、hint 索引文件和事务序列号文件使用的 AES 密钥，长度为 16、24 或 32 字节，为空表示不加密
+	EncryptionKey []byte
+	// 提供加密密钥，优先于 EncryptionKey，可以用来轮换密钥
+	// 轮换之后 merge 会使用新的密钥重写数据，旧的密钥需要保留到没有数据文件使用它为止
+	KeyProvider KeyProvider
+}
+
+// AutoMergeOptions 后台自动 merge 的配置
+type AutoMergeOptions struct {
+	// 是否开启后台自动 merge
+	Enable bool
+	// 检查是否需要 merge 的时间间隔
+	CheckInterval time.Duration
+	// 可回收的数据量占比达到该阈值时触发 merge，为 0 时使用 DataFileMergeRatio
+	MergeRatio float32
+	// 允许执行 merge 的时间窗口，为空表示任何时间都可以执行
+	Windows []MergeWindow
+	// merge 时每秒最多读取的字节数，为 0 表示不限速
+	BytesPerSecond int64
+}
+
+// MergeWindow 一天中允许执行 merge 的时间段，用距离当天零点的时长表示
+// Start 大于 End 时表示跨越零点，例如 22:00 到次日 06:00
+type MergeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// CompactOptions 在线压缩数据文件的配置
+type CompactOptions struct {
+	// 一次最多压缩的数据文件数量，优先压缩无效数据最多的文件，0 表示不限制
+	MaxFiles int
+	// 无效数据占文件大小的比例达到该阈值的文件才会被压缩
+	MinGarbageRatio float32
+}
+
+// MergeOptions 手动 merge 的配置
+type MergeOptions struct {
+	// merge 时每秒最多读取的字节数，为 0 表示不限速
+	BytesPerSecond int64
+	// 每处理完一个数据文件回调一次，为 nil 表示不需要进度
+	Progress func(progress MergeProgress)
 }
 
 type IteratorOptions struct {
 	Prefix  []byte
 	Reverse bool
+	// 遍历的下界，包含在范围内，为空表示没有下界
+	LowerBound []byte
+	// 遍历的上界，不包含在范围内，为空表示没有上界
+	UpperBound []byte
+	// 最多返回的 key 的数量，0 表示不限制，Rewind 和 Seek 之后重新计数
+	Limit int
+	// 只遍历 key，不读取数据文件，Value 会返回 ErrKeysOnlyIterator
+	KeysOnly bool
 }
 
 type WriteBatchOptions struct {
@@ -35,14 +109,74 @@ const (
 	BPlusTree
 )
 
+type RecoveryMode = int8
+
+const (
+	// 遇到损坏的记录时返回错误
+	RecoveryFail RecoveryMode = iota
+	// 跳过校验失败的记录，记录的长度无法确定时仍然返回错误
+	RecoverySkip
+	// 向后查找下一条有效的记录，尽可能多地恢复数据
+	RecoverySalvage
+)
+
+type IOType = fio.FileIOType
+
+const (
+	// 标准文件 IO
+	StandardIO IOType = fio.StandardFIO
+	// 使用 O_DIRECT 读写数据文件，不占用页缓存，只支持 Linux
+	DirectIO IOType = fio.DirectFIO
+	// 使用可读写的 mmap 读写数据文件，活跃文件预先分配 DataFileSize 大小的空间，只支持 unix 系统
+	MMapIO IOType = fio.MemoryMap
+)
+
+type KeyProvider = data.KeyProvider
+
+type CompressionType = data.CompressionType
+
+const (
+	// 不压缩
+	NoCompression CompressionType = data.CompressionNone
+	// snappy 压缩，速度快
+	SnappyCompression CompressionType = data.CompressionSnappy
+	// zstd 压缩，压缩率高
+	ZstdCompression CompressionType = data.CompressionZstd
+	// lz4 压缩
+	LZ4Compression CompressionType = data.CompressionLZ4
+)
+
 var DefaultOptions = Options{
-	DirPath:            os.TempDir(),
-	DataFileSize:       256 * 1024 * 1024,
-	SyncWrite:          false,
-	BytePerSync:        0,
-	IndexType:          BTree,
-	MMapAtStartup:      false,
-	DataFileMergeRatio: 0.5,
+	DirPath:                 os.TempDir(),
+	DataFileSize:            256 * 1024 * 1024,
+	SyncWrite:               false,
+	BytePerSync:             0,
+	IndexType:               BTree,
+	IndexShards:             0,
+	IndexLoadWorkers:        4,
+	IndexCheckpointInterval: 0,
+	MMapAtStartup:           false,
+	IOType:                  StandardIO,
+	DataFileMergeRatio:      0.5,
+	AutoMerge: AutoMergeOptions{
+		Enable:         false,
+		CheckInterval:  time.Minute,
+		MergeRatio:     0,
+		Windows:        nil,
+		BytesPerSecond: 0,
+	},
+	RecoveryMode: RecoveryFail,
+	Compression:  NoCompression,
+}
+
+var DefaultCompactOptions = CompactOptions{
+	MaxFiles:        4,
+	MinGarbageRatio: 0.5,
+}
+
+var DefaultMergeOptions = MergeOptions{
+	BytesPerSecond: 0,
+	Progress:       nil,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
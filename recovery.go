@@ -0,0 +1,199 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"io"
+	"log"
+	"sync/atomic"
+)
+
+// 遍历数据文件中的所有记录，返回最后一条记录的结束位置
+// 活跃文件中损坏的记录之后如果没有有效的数据，说明是写入过程中崩溃留下的，会被截断；
+// 其他的损坏按照 RecoveryMode 处理
+func (db *DB) scanDataFile(dataFile *data.DataFile, isActive bool,
+	fn func(logRecord *data.LogRecord, offset, size int64) error) (int64, error) {
+	var offset int64 = 0
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err == nil {
+			if err := fn(logRecord, offset, size); err != nil {
+				return 0, err
+			}
+			offset += size
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != data.ErrInvalidCRC && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+
+		// 查找损坏位置之后的下一条有效记录
+		var nextOffset int64
+		var findErr error
+		if isActive || db.options.RecoveryMode == RecoverySalvage {
+			nextOffset, findErr = dataFile.FindNextRecord(offset + 1)
+			if findErr != nil && findErr != io.EOF {
+				return 0, findErr
+			}
+		}
+		if isActive && findErr == io.EOF {
+			fileSize, err := dataFile.IoManager.Size()
+			if err != nil {
+				return 0, err
+			}
+			log.Printf("truncate torn tail of data file %d at offset %d, %d bytes dropped",
+				dataFile.FileId, offset, fileSize-offset)
+			if err := dataFile.Truncate(offset); err != nil {
+				return 0, err
+			}
+			break
+		}
+
+		switch db.options.RecoveryMode {
+		case RecoverySkip:
+			// 记录的长度都已经损坏，无法跳过
+			if err != data.ErrInvalidCRC {
+				return 0, err
+			}
+			log.Printf("skip corrupted record in data file %d at offset %d, %d bytes", dataFile.FileId, offset, size)
+			offset += size
+		case RecoverySalvage:
+			if findErr == io.EOF {
+				log.Printf("drop corrupted data in data file %d from offset %d to the end", dataFile.FileId, offset)
+				return offset, nil
+			}
+			log.Printf("drop corrupted data in data file %d from offset %d to %d", dataFile.FileId, offset, nextOffset)
+			offset = nextOffset
+		default:
+			return 0, err
+		}
+	}
+	// 预先分配的空间在崩溃之后会留在活跃文件的末尾，需要截断之后才能继续追加写入
+	if isActive {
+		fileSize, err := dataFile.IoManager.Size()
+		if err != nil {
+			return 0, err
+		}
+		if fileSize > offset {
+			log.Printf("truncate zero tail of data file %d at offset %d, %d bytes dropped",
+				dataFile.FileId, offset, fileSize-offset)
+			if err := dataFile.Truncate(offset); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return offset, nil
+}
+
+// 判断数据文件是否存在并且包含 offset 之前的数据
+func (db *DB) containsDataFileOffset(fid uint32, offset int64) (bool, error) {
+	var dataFile *data.DataFile
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		dataFile = db.activeFile
+	} else {
+		dataFile = db.olderFiles[fid]
+	}
+	if dataFile == nil {
+		return false, nil
+	}
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return false, err
+	}
+	return fileSize >= offset, nil
+}
+
+// 读取 B+ 树索引中记录的已经应用到的位置，没有记录或者位置不可用时返回 nil，之后重放全部的数据文件
+// 旧版本的索引文件中没有记录位置，会重放一次全部的数据文件
+func (db *DB) loadAppliedPosition() (*indexCheckpoint, error) {
+	indexer, ok := db.index.(index.PersistentIndexer)
+	if !ok {
+		return nil, nil
+	}
+	pos, err := indexer.AppliedPosition()
+	if err != nil {
+		log.Printf("failed to read applied position of index, replay all data files: %v", err)
+		pos = nil
+	}
+	// 崩溃之后数据文件中没有持久化的数据可能丢失，位置之前的数据已经不完整
+	if pos != nil {
+		ok, err := db.containsDataFileOffset(pos.Fid, pos.Offset)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			log.Printf("data file %d does not contain applied offset %d of index, replay all data files", pos.Fid, pos.Offset)
+			pos = nil
+		}
+	}
+	// 索引中可能有已经丢失或者被 merge、压缩删除的数据的位置，清空之后重新加载
+	if pos == nil {
+		return nil, indexer.Reset()
+	}
+	db.seqNo = pos.SeqNo
+	db.restoreDeadSizes(pos.DeadSizes)
+	db.restoreValueSizes(pos.ValueSizes, pos.CompressedValueSizes)
+	for _, size := range pos.DeadSizes {
+		db.reclaimSize += size
+	}
+	return &indexCheckpoint{fid: pos.Fid, offset: pos.Offset, seqNo: pos.SeqNo}, nil
+}
+
+// 记录 B+ 树索引已经应用到的位置，之前写入的数据重启时不需要重放，需要持有 db.mu
+func (db *DB) saveAppliedPosition() error {
+	indexer, ok := db.index.(index.PersistentIndexer)
+	if !ok || db.activeFile == nil {
+		return nil
+	}
+	err := indexer.SetAppliedPosition(&index.AppliedPosition{
+		Fid:       db.activeFile.FileId,
+		Offset:    db.activeFile.WriteOff,
+		SeqNo:     atomic.LoadUint64(&db.seqNo),
+		DeadSizes: db.deadSizes,
+		// 记录的位置所在的文件启动时会重新读取，其他文件的大小不会再变
+		ValueSizes:           db.valueSizes,
+		CompressedValueSizes: db.compressedValueSizes,
+	})
+	if err == nil {
+		db.appliedFileId = db.activeFile.FileId
+	}
+	return err
+}
+
+// 写入之后只在活跃文件切换时记录位置，每次写入都记录会让 B+ 树索引多一次事务提交
+// 崩溃之后最多重放一个数据文件，需要持有 db.mu
+func (db *DB) saveAppliedPositionOnRotate() error {
+	if db.activeFile == nil || db.activeFile.FileId == db.appliedFileId {
+		return nil
+	}
+	return db.saveAppliedPosition()
+}
+
+// 崩溃之后重放了记录的位置之后写入的数据，这些数据覆盖的旧位置在重放时已经不在索引中了，
+// 根据索引中仍然有效的数据重新计算每个数据文件可以回收的数据量，需要遍历一次索引
+func (db *DB) rebuildDeadSizes() {
+	liveSizes := make(map[uint32]int64)
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if pos := iterator.Value(); !pos.IsExpired() {
+			liveSizes[pos.Fid] += int64(pos.Size)
+		}
+	}
+	iterator.Close()
+
+	db.deadSizes = make(map[uint32]int64)
+	db.reclaimSize = 0
+	for _, stat := range db.dataFileStats() {
+		deadSize := max(stat.Size-liveSizes[stat.FileId], 0)
+		db.deadSizes[stat.FileId] = deadSize
+		db.reclaimSize += deadSize
+	}
+}
+
+// 两个位置是否指向同一条记录
+func samePos(a, b *data.LogRecordPos) bool {
+	return b != nil && a.Fid == b.Fid && a.Offset == b.Offset
+}
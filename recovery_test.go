@@ -0,0 +1,266 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_RecoverTornTail(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-tail")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 模拟写入过程中崩溃，末尾只写了一部分记录
+	fileName := data.GetDataFileName(dir, 0)
+	stat, _ := os.Stat(fileName)
+	goodSize := stat.Size()
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("torn"), NonTransitionSeqNo),
+		Value: utils.RandomValue(100),
+	})
+	f, _ := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	_, _ = f.Write(encRecord[:len(encRecord)/2])
+	_ = f.Close()
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	stat, _ = os.Stat(fileName)
+	assert.Equal(t, goodSize, stat.Size())
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	// 截断之后可以正常写入
+	err = db.Put([]byte("after"), []byte("recovery"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("after"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("recovery"), val)
+}
+
+func TestDB_RecoveryMode(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-mode")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 破坏第一个数据文件中第二条记录的 value
+	fileName := data.GetDataFileName(dir, 0)
+	encRecord, size := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(utils.GetTestKey(0), NonTransitionSeqNo),
+		Value: utils.GetTestKey(0),
+	})
+	assert.Equal(t, size, int64(len(encRecord)))
+	f, _ := os.OpenFile(fileName, os.O_WRONLY, 0644)
+	_, _ = f.WriteAt([]byte{0xff}, 2*size-1)
+	_ = f.Close()
+
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+
+	for _, mode := range []RecoveryMode{RecoverySkip, RecoverySalvage} {
+		opts.RecoveryMode = mode
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		_, err = db.Get(utils.GetTestKey(1))
+		assert.Equal(t, ErrKeyNotFound, err)
+		for i := 2; i < 1000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, utils.GetTestKey(i), val)
+		}
+		err = db.Close()
+		assert.Nil(t, err)
+	}
+
+	// 破坏记录的长度之后只能通过 salvage 恢复
+	f, _ = os.OpenFile(fileName, os.O_WRONLY, 0644)
+	_, _ = f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}, 5)
+	_ = f.Close()
+	opts.RecoveryMode = RecoverySkip
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+	opts.RecoveryMode = RecoverySalvage
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 2; i < 1000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_BPlusTreeRecovery(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-bptree")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	expected := make(map[string][]byte)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+		expected[string(utils.GetTestKey(i))] = utils.GetTestKey(i)
+	}
+	// 保存此时的 B+ 树索引文件，模拟崩溃之后索引只持久化到了这里
+	indexFileName := filepath.Join(dir, index.BptreeIndexFileName)
+	indexFile, err := os.ReadFile(indexFileName)
+	assert.Nil(t, err)
+
+	for i := 400; i < 900; i++ {
+		value := utils.RandomValue(32)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+		expected[string(utils.GetTestKey(i))] = value
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	delete(expected, string(utils.GetTestKey(1)))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Delete(utils.GetTestKey(2)))
+	assert.Nil(t, wb.Put(utils.GetTestKey(3), []byte("batch value")))
+	assert.Nil(t, wb.Commit())
+	delete(expected, string(utils.GetTestKey(2)))
+	expected[string(utils.GetTestKey(3))] = []byte("batch value")
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.WriteFile(indexFileName, indexFile, 0644))
+
+	check := func() {
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		assert.Equal(t, len(expected), len(db.ListKeys()))
+		for key, value := range expected {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		// 没有事务序列号文件时也可以使用 WriteBatch
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Put(utils.GetTestKey(4), []byte("value")))
+		assert.Nil(t, wb.Commit())
+		expected[string(utils.GetTestKey(4))] = []byte("value")
+		assert.Nil(t, db.Close())
+	}
+	check()
+
+	// 旧版本的索引文件中没有记录位置，重放全部的数据文件
+	assert.Nil(t, os.Remove(filepath.Join(dir, data.SeqNoFileName)))
+	bpt := index.NewBPlusTree(dir, false)
+	assert.Nil(t, bpt.SetAppliedPosition(nil))
+	assert.Nil(t, bpt.Close())
+	check()
+
+	// 预先分配的空间在崩溃之后留在活跃文件的末尾
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	var activeFileName string
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == data.DataFileNameSuffix {
+			activeFileName = filepath.Join(dir, entry.Name())
+		}
+	}
+	f, err := os.OpenFile(activeFileName, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write(make([]byte, 4096))
+	assert.Nil(t, err)
+	_ = f.Close()
+	check()
+
+	// 索引记录的位置之后的数据没有持久化就崩溃了，索引中丢失的数据的位置不能留下
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	writeOff := db.activeFile.WriteOff
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("lost-key-%d", i)), utils.RandomValue(32)))
+	}
+	assert.Nil(t, db.Close())
+	assert.Nil(t, os.Truncate(activeFileName, writeOff))
+	check()
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(1000+i), utils.GetTestKey(1000+i)))
+	}
+	for i := 0; i < 10; i++ {
+		_, err := db.Get([]byte(fmt.Sprintf("lost-key-%d", i)))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	assert.Nil(t, db.Close())
+}
+
+func TestDB_BPlusTreeRecovery_DeadSizes(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-bptree-dead")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	applied, err := db.index.(index.PersistentIndexer).AppliedPosition()
+	assert.Nil(t, err)
+
+	// 覆盖记录的位置之前写入的数据
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	expected := db.dataFileStats()
+	reclaimSize := db.reclaimSize
+	assert.True(t, reclaimSize > 0)
+	assert.Nil(t, db.Close())
+
+	// 模拟崩溃：索引中记录的位置停留在覆盖之前
+	bpt := index.NewBPlusTree(dir, false)
+	assert.Nil(t, bpt.SetAppliedPosition(applied))
+	assert.Nil(t, bpt.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, db.dataFileStats())
+	assert.Equal(t, reclaimSize, db.reclaimSize)
+	assert.Equal(t, 500, len(db.ListKeys()))
+}
@@ -4,26 +4,86 @@ import (
 	bitcask_go "bitcask-go"
 	"bitcask-go/redis"
 	"bitcask-go/utils"
+	"errors"
 	"fmt"
 	"github.com/tidwall/redcon"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type cmdHandler func(cli *BitcaskClient, args [][]byte) (interface{}, error)
 
 var supportCommands = map[string]cmdHandler{
-	"set":   set,
-	"get":   get,
-	"hset":  hset,
+	// quit 和 ping 在 execClientCommand 中直接处理
+	"quit": nil,
+	"ping": nil,
+
+	// string
+	"set":      set,
+	"setnx":    setnx,
+	"get":      get,
+	"getset":   getset,
+	"mget":     mget,
+	"mset":     mset,
+	"incr":     incr,
+	"incrby":   incrby,
+	"decr":     decr,
+	"append":   appendValue,
+	"strlen":   strlen,
+	"getrange": getrange,
+	"setrange": setrange,
+
+	// generic
+	"exists":  exists,
+	"del":     del,
+	"type":    typeOf,
+	"expire":  expire,
+	"ttl":     ttl,
+	"persist": persist,
+
+	// hash
+	"hset":         hset,
+	"hmset":        hmset,
+	"hsetnx":       hsetnx,
+	"hget":         hget,
+	"hmget":        hmget,
+	"hdel":         hdel,
+	"hgetall":      hgetall,
+	"hkeys":        hkeys,
+	"hvals":        hvals,
+	"hlen":         hlen,
+	"hexists":      hexists,
+	"hincrby":      hincrby,
+	"hincrbyfloat": hincrbyfloat,
+	"hscan":        hscan,
+
 	"sadd":  sadd,
 	"lpush": lpush,
 	"zadd":  zadd,
 }
 
+var errSyntax = errors.New("ERR syntax error")
+
 func newWrongNumofArgsError(cmd string) error {
 	return fmt.Errorf("Err wrong num of args of cmd %v", cmd)
 }
 
+func parseInt(arg []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, redis.ErrNotInteger
+	}
+	return n, nil
+}
+
+func boolToInt(b bool) redcon.SimpleInt {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 type BitcaskClient struct {
 	server *BitcaskServer
 	db     *redis.RedisDataStructure
@@ -39,6 +99,7 @@ func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
 	client, _ := conn.Context().(*BitcaskClient)
 	switch command {
 	case "quit":
+		conn.WriteString("OK")
 		_ = conn.Close()
 	case "ping":
 		conn.WriteString("PONG")
@@ -56,17 +117,76 @@ func execClientCommand(conn redcon.Conn, cmd redcon.Command) {
 	}
 }
 
+// SET key value [EX seconds|PX milliseconds] [NX|XX]
 func set(cli *BitcaskClient, args [][]byte) (interface{}, error) {
-	if len(args) != 2 {
+	if len(args) < 2 {
 		return nil, newWrongNumofArgsError("set")
 	}
 	key, value := args[0], args[1]
-	if err := cli.db.Set(key, 0, value); err != nil {
+	var ttl time.Duration
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); opt {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ex", "px":
+			if i+1 >= len(args) {
+				return nil, errSyntax
+			}
+			n, err := parseInt(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if n <= 0 {
+				return nil, errors.New("ERR invalid expire time in 'set' command")
+			}
+			if opt == "ex" {
+				ttl = time.Duration(n) * time.Second
+			} else {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+			i++
+		default:
+			return nil, errSyntax
+		}
+	}
+	if nx && xx {
+		return nil, errSyntax
+	}
+
+	var ok = true
+	var err error
+	switch {
+	case nx:
+		ok, err = cli.db.SetNX(key, ttl, value)
+	case xx:
+		ok, err = cli.db.SetXX(key, ttl, value)
+	default:
+		err = cli.db.Set(key, ttl, value)
+	}
+	if err != nil {
 		return nil, err
 	}
+	// 条件不满足时返回 nil
+	if !ok {
+		return nil, nil
+	}
 	return redcon.SimpleString("OK"), nil
 }
 
+func setnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumofArgsError("setnx")
+	}
+	ok, err := cli.db.SetNX(args[0], 0, args[1])
+	if err != nil {
+		return nil, err
+	}
+	return boolToInt(ok), nil
+}
+
 func get(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 1 {
 		return nil, newWrongNumofArgsError("get")
@@ -76,23 +196,424 @@ func get(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	// 已经过期
+	if value == nil {
+		return nil, nil
+	}
 	return value, nil
 }
 
-func hset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+func getset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumofArgsError("getset")
+	}
+	value, err := cli.db.GetSet(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return value, nil
+}
+
+func mget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, newWrongNumofArgsError("mget")
+	}
+	values, err := cli.db.MGet(args...)
+	if err != nil {
+		return nil, err
+	}
+	return bulkArray(values), nil
+}
+
+func mset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		return nil, newWrongNumofArgsError("mset")
+	}
+	if err := cli.db.MSet(args...); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func incr(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumofArgsError("incr")
+	}
+	return incrByDelta(cli, args[0], 1)
+}
+
+func incrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumofArgsError("incrby")
+	}
+	delta, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return incrByDelta(cli, args[0], delta)
+}
+
+func decr(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumofArgsError("decr")
+	}
+	return incrByDelta(cli, args[0], -1)
+}
+
+func incrByDelta(cli *BitcaskClient, key []byte, delta int64) (interface{}, error) {
+	res, err := cli.db.IncrBy(key, delta)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func appendValue(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumofArgsError("append")
+	}
+	length, err := cli.db.Append(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(length), nil
+}
+
+func strlen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumofArgsError("strlen")
+	}
+	length, err := cli.db.StrLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(length), nil
+}
+
+func getrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumofArgsError("getrange")
+	}
+	start, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	return cli.db.GetRange(args[0], int(start), int(end))
+}
+
+func setrange(cli *BitcaskClient, args [][]byte) (interface{}, error) {
 	if len(args) != 3 {
+		return nil, newWrongNumofArgsError("setrange")
+	}
+	offset, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	length, err := cli.db.SetRange(args[0], int(offset), args[2])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(length), nil
+}
+
+func exists(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, newWrongNumofArgsError("exists")
+	}
+	count, err := cli.db.Exists(args...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(count), nil
+}
+
+func del(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, newWrongNumofArgsError("del")
+	}
+	count, err := cli.db.DelKeys(args...)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(count), nil
+}
+
+var typeNames = map[redis.RedisDataType]string{
+	redis.String: "string",
+	redis.Hash:   "hash",
+	redis.Set:    "set",
+	redis.List:   "list",
+	redis.ZSet:   "zset",
+}
+
+func typeOf(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumofArgsError("type")
+	}
+	typ, err := cli.db.Type(args[0])
+	if err == bitcask_go.ErrKeyNotFound {
+		return redcon.SimpleString("none"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString(typeNames[typ]), nil
+}
+
+func expire(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumofArgsError("expire")
+	}
+	seconds, err := parseInt(args[1])
+	if err != nil {
+		return nil, err
+	}
+	ok, err := cli.db.Expire(args[0], time.Duration(seconds)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return boolToInt(ok), nil
+}
+
+// key 不存在时返回 -2，没有过期时间时返回 -1
+func ttl(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumofArgsError("ttl")
+	}
+	remain, err := cli.db.TTL(args[0])
+	if err == bitcask_go.ErrKeyNotFound {
+		return redcon.SimpleInt(-2), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remain < 0 {
+		return redcon.SimpleInt(-1), nil
+	}
+	// 和 redis 一样四舍五入到秒
+	return redcon.SimpleInt((remain + time.Second/2) / time.Second), nil
+}
+
+func persist(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumofArgsError("persist")
+	}
+	ok, err := cli.db.Persist(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return boolToInt(ok), nil
+}
+
+// HSET key field value [field value ...]，返回新增的 field 的数量
+func hset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
 		return nil, newWrongNumofArgsError("hset")
 	}
-	var ok = 0
-	key, field, value := args[0], args[1], args[2]
-	res, err := cli.db.HSet(key, field, value)
+	added, err := cli.db.HMSet(args[0], args[1:]...)
 	if err != nil {
 		return nil, err
 	}
-	if res {
-		ok = 1
+	return redcon.SimpleInt(added), nil
+}
+
+func hmset(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 3 || len(args)%2 == 0 {
+		return nil, newWrongNumofArgsError("hmset")
 	}
-	return redcon.SimpleInt(ok), nil
+	if _, err := cli.db.HMSet(args[0], args[1:]...); err != nil {
+		return nil, err
+	}
+	return redcon.SimpleString("OK"), nil
+}
+
+func hsetnx(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumofArgsError("hsetnx")
+	}
+	ok, err := cli.db.HSetNX(args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return boolToInt(ok), nil
+}
+
+func hget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumofArgsError("hget")
+	}
+	value, err := cli.db.HGet(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	return value, nil
+}
+
+func hmget(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumofArgsError("hmget")
+	}
+	values, err := cli.db.HMGet(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return bulkArray(values), nil
+}
+
+func hdel(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, newWrongNumofArgsError("hdel")
+	}
+	var count int
+	for _, field := range args[1:] {
+		ok, err := cli.db.HDel(args[0], field)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			count++
+		}
+	}
+	return redcon.SimpleInt(count), nil
+}
+
+func hgetall(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumofArgsError("hgetall")
+	}
+	res, err := cli.db.HGetAll(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return bulkArray(res), nil
+}
+
+func hkeys(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumofArgsError("hkeys")
+	}
+	fields, err := cli.db.HKeys(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return bulkArray(fields), nil
+}
+
+func hvals(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumofArgsError("hvals")
+	}
+	values, err := cli.db.HVals(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return bulkArray(values), nil
+}
+
+func hlen(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, newWrongNumofArgsError("hlen")
+	}
+	size, err := cli.db.HLen(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(size), nil
+}
+
+func hexists(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, newWrongNumofArgsError("hexists")
+	}
+	ok, err := cli.db.HExists(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return boolToInt(ok), nil
+}
+
+func hincrby(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumofArgsError("hincrby")
+	}
+	delta, err := parseInt(args[2])
+	if err != nil {
+		return nil, err
+	}
+	res, err := cli.db.HIncrBy(args[0], args[1], delta)
+	if err != nil {
+		return nil, err
+	}
+	return redcon.SimpleInt(res), nil
+}
+
+func hincrbyfloat(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, newWrongNumofArgsError("hincrbyfloat")
+	}
+	delta, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil {
+		return nil, errors.New("ERR value is not a valid float")
+	}
+	res, err := cli.db.HIncrByFloat(args[0], args[1], delta)
+	if err != nil {
+		return nil, err
+	}
+	return strconv.FormatFloat(res, 'f', -1, 64), nil
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func hscan(cli *BitcaskClient, args [][]byte) (interface{}, error) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return nil, newWrongNumofArgsError("hscan")
+	}
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return nil, errors.New("ERR invalid cursor")
+	}
+	var match []byte
+	var count int64
+	for i := 2; i < len(args); i += 2 {
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			match = args[i+1]
+		case "count":
+			if count, err = parseInt(args[i+1]); err != nil {
+				return nil, err
+			}
+			if count < 1 {
+				return nil, errSyntax
+			}
+		default:
+			return nil, errSyntax
+		}
+	}
+	next, res, err := cli.db.HScan(args[0], cursor, match, int(count))
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{strconv.FormatUint(next, 10), bulkArray(res)}, nil
+}
+
+// 转换成数组回复，nil 的元素回复 null
+func bulkArray(values [][]byte) []interface{} {
+	res := make([]interface{}, len(values))
+	for i, value := range values {
+		if value != nil {
+			res[i] = value
+		}
+	}
+	return res
 }
 
 func sadd(cli *BitcaskClient, args [][]byte) (interface{}, error) {
@@ -1,18 +1,179 @@
 package redis
 
-import "errors"
+import (
+	bitcask_go "bitcask-go"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+var ErrWrongTypeOperation = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
 
 func (rds *RedisDataStructure) Del(key []byte) error {
 	return rds.db.Delete(key)
 }
 
+// DelKeys 删除多个 key，返回实际删除的数量，已经过期的 key 不计算在内
+func (rds *RedisDataStructure) DelKeys(keys ...[]byte) (int, error) {
+	var count int
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		count = 0
+		for _, key := range keys {
+			_, err := rds.getAlive(txn, key)
+			if err == bitcask_go.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			count++
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Exists 返回存在的 key 的数量，同一个 key 出现多次时重复计算
+func (rds *RedisDataStructure) Exists(keys ...[]byte) (int, error) {
+	var count int
+	for _, key := range keys {
+		_, err := rds.getAlive(rds.db, key)
+		if err == bitcask_go.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
 func (rds *RedisDataStructure) Type(key []byte) (RedisDataType, error) {
-	envValue, err := rds.db.Get(key)
+	buf, err := rds.getAlive(rds.db, key)
+	if err != nil {
+		return 0, err
+	}
+	typ, _ := decodeTypeAndExpire(buf)
+	return typ, nil
+}
+
+// Expire 设置 key 的过期时间，ttl 小于等于 0 时直接删除 key，key 不存在时返回 false
+func (rds *RedisDataStructure) Expire(key []byte, ttl time.Duration) (bool, error) {
+	var exist bool
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		buf, err := rds.getAlive(txn, key)
+		if exist = err == nil; !exist {
+			if err == bitcask_go.ErrKeyNotFound {
+				err = nil
+			}
+			return err
+		}
+		if ttl <= 0 {
+			return txn.Delete(key)
+		}
+		expire := time.Now().Add(ttl).UnixNano()
+		return putWithExpire(txn, key, withExpire(buf, expire), expire)
+	})
+	if err != nil {
+		return false, err
+	}
+	return exist, nil
+}
+
+// TTL 返回 key 剩余的存活时间，没有过期时间时返回 -1，key 不存在时返回 ErrKeyNotFound
+func (rds *RedisDataStructure) TTL(key []byte) (time.Duration, error) {
+	buf, err := rds.getAlive(rds.db, key)
 	if err != nil {
 		return 0, err
 	}
-	if len(envValue) == 0 {
-		return 0, errors.New("value is null")
+	_, expire := decodeTypeAndExpire(buf)
+	if expire == 0 {
+		return -1, nil
+	}
+	return max(time.Duration(expire-time.Now().UnixNano()), 0), nil
+}
+
+// Persist 清除 key 的过期时间，key 不存在或者没有过期时间时返回 false
+func (rds *RedisDataStructure) Persist(key []byte) (bool, error) {
+	var ok bool
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		buf, err := rds.getAlive(txn, key)
+		if err == bitcask_go.ErrKeyNotFound {
+			ok = false
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, expire := decodeTypeAndExpire(buf); expire == 0 {
+			ok = false
+			return nil
+		}
+		ok = true
+		return txn.Put(key, withExpire(buf, 0))
+	})
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// 读取 key 的原始数据，不区分数据类型，key 不存在或者已经过期时返回 ErrKeyNotFound
+func (rds *RedisDataStructure) getAlive(r reader, key []byte) ([]byte, error) {
+	buf, err := r.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) == 0 {
+		return nil, bitcask_go.ErrKeyNotFound
+	}
+	if _, expire := decodeTypeAndExpire(buf); isExpired(expire) {
+		return nil, bitcask_go.ErrKeyNotFound
+	}
+	return buf, nil
+}
+
+// 所有类型的数据（String 的值和其他类型的元数据）都以数据类型和过期时间开头
+func decodeTypeAndExpire(buf []byte) (RedisDataType, int64) {
+	expire, _ := binary.Varint(buf[1:])
+	return buf[0], expire
+}
+
+// 替换数据中的过期时间，其余部分保持不变
+func withExpire(buf []byte, expire int64) []byte {
+	_, n := binary.Varint(buf[1:])
+	newBuf := make([]byte, 1+binary.MaxVarintLen64+len(buf)-1-n)
+	newBuf[0] = buf[0]
+	var index = 1
+	index += binary.PutVarint(newBuf[index:], expire)
+	index += copy(newBuf[index:], buf[1+n:])
+	return newBuf[:index]
+}
+
+func isExpired(expire int64) bool {
+	return expire > 0 && expire <= time.Now().UnixNano()
+}
+
+// 写入带有过期时间的值，过期时间同时交给存储引擎处理，过期之后的数据在 merge 时可以被回收
+// 引擎的过期时间根据剩余的存活时间计算，只会比 expire 稍晚，不会提前删除数据
+func putWithExpire(txn *bitcask_go.Txn, key, value []byte, expire int64) error {
+	if expire == 0 {
+		return txn.Put(key, value)
+	}
+	return txn.PutWithTTL(key, value, max(time.Duration(expire-time.Now().UnixNano()), 1))
+}
+
+// 根据存活时间计算过期的时间点，0 表示永不过期
+func expireAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
 	}
-	return RedisDataType(envValue[0]), nil
+	return time.Now().Add(ttl).UnixNano()
 }
@@ -0,0 +1,84 @@
+package redis
+
+// 和 redis 相同的 glob 匹配规则，用于 SCAN 类命令的 MATCH 参数
+// * 匹配任意个字符，? 匹配一个字符，[abc]、[^a]、[a-z] 匹配字符集合，\ 转义下一个字符
+func globMatch(pattern, str []byte) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if globMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			matched, n := matchCharClass(pattern[1:], str[0])
+			// 没有闭合的 [ 当作普通字符
+			if n < 0 {
+				if str[0] != '[' {
+					return false
+				}
+				break
+			}
+			if !matched {
+				return false
+			}
+			pattern = pattern[n:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || str[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, str = pattern[1:], str[1:]
+	}
+	return len(str) == 0
+}
+
+// 匹配 [ 之后的字符集合，返回是否匹配以及字符集合占用的长度（包括 ]），没有闭合时返回 -1
+func matchCharClass(class []byte, c byte) (bool, int) {
+	var matched, not bool
+	var i = 0
+	if i < len(class) && class[i] == '^' {
+		not = true
+		i++
+	}
+	for ; i < len(class) && class[i] != ']'; i++ {
+		switch {
+		case class[i] == '\\' && i+1 < len(class):
+			i++
+			matched = matched || class[i] == c
+		case i+2 < len(class) && class[i+1] == '-' && class[i+2] != ']':
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			i += 2
+		default:
+			matched = matched || class[i] == c
+		}
+	}
+	if i >= len(class) {
+		return false, -1
+	}
+	return matched != not, i + 1
+}
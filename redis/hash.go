@@ -0,0 +1,345 @@
+package redis
+
+import (
+	bitcask_go "bitcask-go"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+var (
+	ErrHashValueNotInteger = errors.New("ERR hash value is not an integer")
+	ErrHashValueNotFloat   = errors.New("ERR hash value is not a float")
+	ErrIncrNaNOrInfinity   = errors.New("ERR increment would produce NaN or Infinity")
+)
+
+// 没有指定 COUNT 时 HScan 每次遍历的 field 数量
+const defaultScanCount = 10
+
+// HMSet 写入多个 field，参数是交替的 field 和 value，返回新增的 field 的数量
+func (rds *RedisDataStructure) HMSet(key []byte, pairs ...[]byte) (int, error) {
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return 0, errors.New("ERR wrong number of arguments for HMSET")
+	}
+	var added int
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		added = 0
+		meta, err := rds.findMetadata(txn, key, Hash)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			hk := &hashInternalKey{key: key, version: meta.version, field: pairs[i]}
+			encKey := hk.encode()
+			exist, err := keyExists(txn, encKey)
+			if err != nil {
+				return err
+			}
+			if !exist {
+				added++
+			}
+			if err := txn.Put(encKey, pairs[i+1]); err != nil {
+				return err
+			}
+		}
+		if added > 0 {
+			meta.size += uint32(added)
+			return putWithExpire(txn, key, meta.encode(), meta.expire)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// HSetNX field 不存在时才写入，返回是否写入
+func (rds *RedisDataStructure) HSetNX(key, field, value []byte) (bool, error) {
+	var ok bool
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		meta, err := rds.findMetadata(txn, key, Hash)
+		if err != nil {
+			return err
+		}
+		hk := &hashInternalKey{key: key, version: meta.version, field: field}
+		encKey := hk.encode()
+		exist, err := keyExists(txn, encKey)
+		if ok = !exist; err != nil || exist {
+			return err
+		}
+		meta.size++
+		if err := putWithExpire(txn, key, meta.encode(), meta.expire); err != nil {
+			return err
+		}
+		return txn.Put(encKey, value)
+	})
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// HMGet 读取多个 field，不存在的 field 对应的值为 nil
+func (rds *RedisDataStructure) HMGet(key []byte, fields ...[]byte) ([][]byte, error) {
+	meta, err := rds.findMetadata(rds.db, key, Hash)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(fields))
+	if meta.size == 0 {
+		return values, nil
+	}
+	for i, field := range fields {
+		hk := &hashInternalKey{key: key, version: meta.version, field: field}
+		value, err := rds.db.Get(hk.encode())
+		if err == bitcask_go.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// 空的 value 和不存在的 field 区分开
+		if value == nil {
+			value = []byte{}
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// HGetAll 返回所有的 field 和 value，按照 field 的顺序交替排列
+func (rds *RedisDataStructure) HGetAll(key []byte) ([][]byte, error) {
+	var res [][]byte
+	err := rds.hashIterate(key, nil, false, func(field, value []byte) bool {
+		res = append(res, field, value)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (rds *RedisDataStructure) HKeys(key []byte) ([][]byte, error) {
+	var fields [][]byte
+	err := rds.hashIterate(key, nil, true, func(field, _ []byte) bool {
+		fields = append(fields, field)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func (rds *RedisDataStructure) HVals(key []byte) ([][]byte, error) {
+	var values [][]byte
+	err := rds.hashIterate(key, nil, false, func(_, value []byte) bool {
+		values = append(values, value)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func (rds *RedisDataStructure) HLen(key []byte) (uint32, error) {
+	meta, err := rds.findMetadata(rds.db, key, Hash)
+	if err != nil {
+		return 0, err
+	}
+	return meta.size, nil
+}
+
+func (rds *RedisDataStructure) HExists(key, field []byte) (bool, error) {
+	meta, err := rds.findMetadata(rds.db, key, Hash)
+	if err != nil {
+		return false, err
+	}
+	if meta.size == 0 {
+		return false, nil
+	}
+	hk := &hashInternalKey{key: key, version: meta.version, field: field}
+	return keyExists(rds.db, hk.encode())
+}
+
+// HIncrBy 把 field 的值加上 delta 并返回结果，field 不存在时从 0 开始
+func (rds *RedisDataStructure) HIncrBy(key, field []byte, delta int64) (int64, error) {
+	var result int64
+	err := rds.hashUpdateField(key, field, func(value []byte, exist bool) ([]byte, error) {
+		var num int64
+		if exist {
+			var err error
+			if num, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				return nil, ErrHashValueNotInteger
+			}
+		}
+		if (delta > 0 && num > math.MaxInt64-delta) || (delta < 0 && num < math.MinInt64-delta) {
+			return nil, ErrIntegerOverflow
+		}
+		result = num + delta
+		return []byte(strconv.FormatInt(result, 10)), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// HIncrByFloat 把 field 的值加上浮点数 delta 并返回结果，field 不存在时从 0 开始
+func (rds *RedisDataStructure) HIncrByFloat(key, field []byte, delta float64) (float64, error) {
+	var result float64
+	err := rds.hashUpdateField(key, field, func(value []byte, exist bool) ([]byte, error) {
+		var num float64
+		if exist {
+			var err error
+			if num, err = strconv.ParseFloat(string(value), 64); err != nil {
+				return nil, ErrHashValueNotFloat
+			}
+		}
+		result = num + delta
+		if math.IsNaN(result) || math.IsInf(result, 0) {
+			return nil, ErrIncrNaNOrInfinity
+		}
+		return []byte(strconv.FormatFloat(result, 'f', -1, 64)), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// HScan 从 cursor 开始按照 field 的顺序遍历 count 个 field，返回其中匹配 match 的 field 和 value 以及下一次的 cursor
+// cursor 是下一个 field 的前 8 个字节组成的整数（见 scanToken），0 表示从头开始，返回 0 表示遍历结束
+// 下一次从 cursor 对应的前缀开始遍历，遍历的过程中增删 field 不会导致其他 field 被跳过，
+// 但是前 8 个字节和 cursor 相同的 field 可能被重复返回；为了保证每次都有进展，
+// 和 cursor 相同的 field 全部在同一次中返回，这时返回的数量可能超过 count
+func (rds *RedisDataStructure) HScan(key []byte, cursor uint64, match []byte, count int) (uint64, [][]byte, error) {
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	var start []byte
+	if cursor != 0 {
+		start = scanTokenPrefix(cursor)
+	}
+	var next uint64
+	var fields [][]byte
+	var scanned int
+	// 跳过的 field 不需要读取 value
+	err := rds.hashIterate(key, start, true, func(field, _ []byte) bool {
+		if scanned >= count {
+			// 还有没有遍历的 field，下一次从这个 field 开始
+			if token := scanToken(field); token != cursor {
+				next = token
+				return false
+			}
+		}
+		scanned++
+		if len(match) == 0 || globMatch(match, field) {
+			fields = append(fields, field)
+		}
+		return true
+	})
+	if err != nil || len(fields) == 0 {
+		return next, nil, err
+	}
+	values, err := rds.HMGet(key, fields...)
+	if err != nil {
+		return 0, nil, err
+	}
+	var res [][]byte
+	for i, value := range values {
+		// 遍历之后被删除的 field
+		if value != nil {
+			res = append(res, fields[i], value)
+		}
+	}
+	return next, res, nil
+}
+
+// 把 field 的前 8 个字节按照大端序转成整数，不足 8 个字节时用 0 补齐
+// field 的顺序和整数的顺序一致：field a < b 时 scanToken(a) <= scanToken(b)
+func scanToken(field []byte) uint64 {
+	var buf [8]byte
+	copy(buf[:], field)
+	return binary.BigEndian.Uint64(buf[:])
+}
+
+// scanToken 等于 token 的 field 中最小的前缀，去掉补齐的 0 之后不会大于其中任何一个 field
+func scanTokenPrefix(token uint64) []byte {
+	buf := binary.BigEndian.AppendUint64(nil, token)
+	return bytes.TrimRight(buf, "\x00")
+}
+
+// 在事务中读取并修改一个 field 的值，fn 返回新的值
+func (rds *RedisDataStructure) hashUpdateField(key, field []byte, fn func(value []byte, exist bool) ([]byte, error)) error {
+	return rds.update(func(txn *bitcask_go.Txn) error {
+		meta, err := rds.findMetadata(txn, key, Hash)
+		if err != nil {
+			return err
+		}
+		hk := &hashInternalKey{key: key, version: meta.version, field: field}
+		encKey := hk.encode()
+		value, err := txn.Get(encKey)
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return err
+		}
+		exist := err == nil
+		newValue, err := fn(value, exist)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			meta.size++
+			if err := putWithExpire(txn, key, meta.encode(), meta.expire); err != nil {
+				return err
+			}
+		}
+		return txn.Put(encKey, newValue)
+	})
+}
+
+// 按照 field 的顺序遍历 Hash，所有 field 的 key 都以 key + version 开头，keysOnly 时 value 为 nil
+// start 不为 nil 时从大于等于 start 的 field 开始遍历
+func (rds *RedisDataStructure) hashIterate(key, start []byte, keysOnly bool, f func(field, value []byte) bool) error {
+	meta, err := rds.findMetadata(rds.db, key, Hash)
+	if err != nil {
+		return err
+	}
+	if meta.size == 0 {
+		return nil
+	}
+	hk := &hashInternalKey{key: key, version: meta.version}
+	prefix := hk.encode()
+	iter := rds.db.NewIterator(bitcask_go.IteratorOptions{Prefix: prefix, KeysOnly: keysOnly})
+	defer iter.Close()
+	if start == nil {
+		iter.Rewind()
+	} else {
+		iter.Seek(append(append([]byte(nil), prefix...), start...))
+	}
+	for ; iter.Valid(); iter.Next() {
+		// 迭代器关闭之后 key 可能失效，需要拷贝
+		field := append([]byte(nil), iter.Key()[len(prefix):]...)
+		var value []byte
+		if !keysOnly {
+			if value, err = iter.Value(); err == bitcask_go.ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if value == nil {
+				value = []byte{}
+			}
+		}
+		if !f(field, value) {
+			break
+		}
+	}
+	return nil
+}
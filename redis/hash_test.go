@@ -0,0 +1,209 @@
+package redis
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+func TestRedisDataStructure_HMSet_HGetAll(t *testing.T) {
+	rds := newTestRedisDataStructure(t, "bitcask-go-redis-hmset")
+	key := utils.GetTestKey(1)
+
+	added, err := rds.HMSet(key, []byte("f1"), []byte("v1"), []byte("f2"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, added)
+	// 同一次调用中重复的 field 只算一次
+	added, err = rds.HMSet(key, []byte("f2"), []byte("v22"), []byte("f3"), []byte("v3"), []byte("f3"), []byte(""))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, added)
+	_, err = rds.HMSet(key, []byte("f4"))
+	assert.NotNil(t, err)
+
+	size, err := rds.HLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), size)
+	all, err := rds.HGetAll(key)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("f1"), []byte("v1"), []byte("f2"), []byte("v22"), []byte("f3"), {}}, all)
+	fields, err := rds.HKeys(key)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("f1"), []byte("f2"), []byte("f3")}, fields)
+	values, err := rds.HVals(key)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), []byte("v22"), {}}, values)
+	values, err = rds.HMGet(key, []byte("f2"), []byte("f5"), []byte("f3"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v22"), nil, {}}, values)
+
+	ok, err := rds.HExists(key, []byte("f1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.HExists(key, []byte("f5"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.HSetNX(key, []byte("f1"), []byte("v"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.HSetNX(key, []byte("f5"), []byte("v5"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// 删除之后重新创建的 Hash 看不到之前的 field
+	assert.Nil(t, rds.Del(key))
+	all, err = rds.HGetAll(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(all))
+	_, err = rds.HSet(key, []byte("f9"), []byte("v9"))
+	assert.Nil(t, err)
+	fields, err = rds.HKeys(key)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("f9")}, fields)
+
+	err = rds.Set(utils.GetTestKey(2), 0, []byte("v"))
+	assert.Nil(t, err)
+	_, err = rds.HGetAll(utils.GetTestKey(2))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestRedisDataStructure_HIncrBy(t *testing.T) {
+	rds := newTestRedisDataStructure(t, "bitcask-go-redis-hincr")
+	key := utils.GetTestKey(1)
+
+	res, err := rds.HIncrBy(key, []byte("count"), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), res)
+	res, err = rds.HIncrBy(key, []byte("count"), -7)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), res)
+
+	f, err := rds.HIncrByFloat(key, []byte("score"), 1.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, f)
+	f, err = rds.HIncrByFloat(key, []byte("count"), 0.25)
+	assert.Nil(t, err)
+	assert.Equal(t, -1.75, f)
+	_, err = rds.HIncrBy(key, []byte("count"), 1)
+	assert.Equal(t, ErrHashValueNotInteger, err)
+
+	_, err = rds.HSet(key, []byte("name"), []byte("abc"))
+	assert.Nil(t, err)
+	_, err = rds.HIncrByFloat(key, []byte("name"), 1)
+	assert.Equal(t, ErrHashValueNotFloat, err)
+
+	size, err := rds.HLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), size)
+}
+
+func TestRedisDataStructure_HScan(t *testing.T) {
+	rds := newTestRedisDataStructure(t, "bitcask-go-redis-hscan")
+	key := utils.GetTestKey(1)
+
+	expected := make(map[string]string)
+	for i := 0; i < 95; i++ {
+		field, value := fmt.Sprintf("field-%03d", i), fmt.Sprintf("value-%d", i)
+		_, err := rds.HSet(key, []byte(field), []byte(value))
+		assert.Nil(t, err)
+		expected[field] = value
+	}
+
+	// 遍历直到 cursor 为 0，cursor 经过字符串转换之后传回来，和客户端的用法相同
+	scan := func(key []byte, match []byte, count int, f func(res [][]byte)) int {
+		var cursor uint64
+		var rounds int
+		for {
+			next, res, err := rds.HScan(key, cursor, match, count)
+			assert.Nil(t, err)
+			f(res)
+			rounds++
+			if next == 0 {
+				return rounds
+			}
+			assert.True(t, next > cursor)
+			cursor, err = strconv.ParseUint(strconv.FormatUint(next, 10), 10, 64)
+			assert.Nil(t, err)
+		}
+	}
+	got := make(map[string]string)
+	rounds := scan(key, nil, 10, func(res [][]byte) {
+		for i := 0; i < len(res); i += 2 {
+			got[string(res[i])] = string(res[i+1])
+		}
+	})
+	assert.Equal(t, expected, got)
+	assert.Equal(t, 10, rounds)
+
+	var matched int
+	scan(key, []byte("field-0[1-2]?"), 0, func(res [][]byte) {
+		matched += len(res) / 2
+	})
+	assert.Equal(t, 20, matched)
+
+	// 遍历的过程中删除已经返回的 field，不会跳过其他 field
+	got = make(map[string]string)
+	scan(key, nil, 10, func(res [][]byte) {
+		for i := 0; i < len(res); i += 2 {
+			got[string(res[i])] = string(res[i+1])
+			_, err := rds.HDel(key, res[i])
+			assert.Nil(t, err)
+		}
+	})
+	assert.Equal(t, expected, got)
+
+	// 前 8 个字节相同的 field 在同一次中全部返回，第一次返回的 field 会被重复返回
+	key = utils.GetTestKey(3)
+	for i := 0; i < 30; i++ {
+		_, err := rds.HSet(key, []byte(fmt.Sprintf("same-prefix-%02d", i)), []byte("v"))
+		assert.Nil(t, err)
+	}
+	_, err := rds.HSet(key, []byte("z"), []byte("v"))
+	assert.Nil(t, err)
+	var sizes []int
+	scan(key, nil, 10, func(res [][]byte) {
+		sizes = append(sizes, len(res)/2)
+	})
+	assert.Equal(t, []int{10, 30, 1}, sizes)
+
+	next, res, err := rds.HScan(utils.GetTestKey(2), 0, nil, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), next)
+	assert.Equal(t, 0, len(res))
+}
+
+func TestScanToken(t *testing.T) {
+	for _, field := range []string{"", "a", "ab\x00", "abcdefgh", "abcdefghij", "\x00\x00\x01"} {
+		token := scanToken([]byte(field))
+		prefix := scanTokenPrefix(token)
+		assert.True(t, bytes.Compare(prefix, []byte(field)) <= 0, field)
+		assert.Equal(t, token, scanToken(prefix), field)
+	}
+	assert.True(t, scanToken([]byte("abc")) < scanToken([]byte("abd")))
+}
+
+func TestGlobMatch(t *testing.T) {
+	for _, c := range []struct {
+		pattern, str string
+		matched      bool
+	}{
+		{"*", "", true},
+		{"*", "abc", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abbb", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"user:*:name", "user:1/2:name", true},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{"a[b", "a[b", true},
+	} {
+		assert.Equal(t, c.matched, globMatch([]byte(c.pattern), []byte(c.str)), c.pattern+" "+c.str)
+	}
+}
@@ -0,0 +1,237 @@
+package redis
+
+import (
+	bitcask_go "bitcask-go"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrNotInteger      = errors.New("ERR value is not an integer or out of range")
+	ErrIntegerOverflow = errors.New("ERR increment or decrement would overflow")
+	ErrOffsetOutRange  = errors.New("ERR offset is out of range")
+	ErrStringTooLong   = errors.New("ERR string exceeds maximum allowed size (512MB)")
+)
+
+// 和 redis 相同，String 类型的值最大为 512MB
+const maxStringSize = 512 * 1024 * 1024
+
+// SetNX key 不存在时才写入，返回是否写入
+func (rds *RedisDataStructure) SetNX(key []byte, ttl time.Duration, value []byte) (bool, error) {
+	return rds.setCond(key, ttl, value, false)
+}
+
+// SetXX key 存在时才写入，返回是否写入
+func (rds *RedisDataStructure) SetXX(key []byte, ttl time.Duration, value []byte) (bool, error) {
+	return rds.setCond(key, ttl, value, true)
+}
+
+// 根据 key 是否存在决定是否写入，任意类型的 key 都算存在
+func (rds *RedisDataStructure) setCond(key []byte, ttl time.Duration, value []byte, mustExist bool) (bool, error) {
+	var ok bool
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		_, err := rds.getAlive(txn, key)
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return err
+		}
+		if ok = (err == nil) == mustExist; !ok {
+			return nil
+		}
+		expire := expireAt(ttl)
+		return putWithExpire(txn, key, encodeStringValue(value, expire), expire)
+	})
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// GetSet 写入新的值并返回旧的值，key 不存在时返回 nil，写入之后清除过期时间
+func (rds *RedisDataStructure) GetSet(key, value []byte) ([]byte, error) {
+	var oldValue []byte
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		var err error
+		if oldValue, _, err = rds.getString(txn, key); err != nil && err != bitcask_go.ErrKeyNotFound {
+			return err
+		}
+		return txn.Put(key, encodeStringValue(value, 0))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return oldValue, nil
+}
+
+// MGet 读取多个 key，不存在或者不是 String 类型的 key 对应的值为 nil
+func (rds *RedisDataStructure) MGet(keys ...[]byte) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		value, _, err := rds.getString(rds.db, key)
+		if err != nil && err != bitcask_go.ErrKeyNotFound && err != ErrWrongTypeOperation {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// MSet 原子地写入多个 key，参数是交替的 key 和 value
+func (rds *RedisDataStructure) MSet(pairs ...[]byte) error {
+	if len(pairs)%2 != 0 {
+		return errors.New("ERR wrong number of arguments for MSET")
+	}
+	return rds.update(func(txn *bitcask_go.Txn) error {
+		for i := 0; i < len(pairs); i += 2 {
+			if err := txn.Put(pairs[i], encodeStringValue(pairs[i+1], 0)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// IncrBy 把 key 的值加上 delta 并返回结果，key 不存在时从 0 开始，保留原来的过期时间
+func (rds *RedisDataStructure) IncrBy(key []byte, delta int64) (int64, error) {
+	var result int64
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		value, expire, err := rds.getString(txn, key)
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return err
+		}
+		var num int64
+		if err == nil {
+			if num, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				return ErrNotInteger
+			}
+		}
+		if (delta > 0 && num > math.MaxInt64-delta) || (delta < 0 && num < math.MinInt64-delta) {
+			return ErrIntegerOverflow
+		}
+		result = num + delta
+		return putWithExpire(txn, key, encodeStringValue([]byte(strconv.FormatInt(result, 10)), expire), expire)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result, nil
+}
+
+// Append 把 value 追加到原来的值之后，返回追加之后的长度
+func (rds *RedisDataStructure) Append(key, value []byte) (int, error) {
+	var length int
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		oldValue, expire, err := rds.getString(txn, key)
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return err
+		}
+		if len(oldValue)+len(value) > maxStringSize {
+			return ErrStringTooLong
+		}
+		newValue := make([]byte, 0, len(oldValue)+len(value))
+		newValue = append(append(newValue, oldValue...), value...)
+		length = len(newValue)
+		return putWithExpire(txn, key, encodeStringValue(newValue, expire), expire)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// StrLen 返回值的长度，key 不存在时返回 0
+func (rds *RedisDataStructure) StrLen(key []byte) (int, error) {
+	value, _, err := rds.getString(rds.db, key)
+	if err == bitcask_go.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(value), nil
+}
+
+// GetRange 返回 [start, end] 范围内的子串，负数表示从末尾开始计算
+func (rds *RedisDataStructure) GetRange(key []byte, start, end int) ([]byte, error) {
+	value, _, err := rds.getString(rds.db, key)
+	if err == bitcask_go.ErrKeyNotFound {
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		start = max(len(value)+start, 0)
+	}
+	if end < 0 {
+		end = len(value) + end
+	}
+	end = min(end, len(value)-1)
+	if start > end {
+		return []byte{}, nil
+	}
+	return value[start : end+1], nil
+}
+
+// SetRange 从 offset 开始覆盖原来的值，不够长时用 0 填充，返回修改之后的长度
+func (rds *RedisDataStructure) SetRange(key []byte, offset int, value []byte) (int, error) {
+	if offset < 0 {
+		return 0, ErrOffsetOutRange
+	}
+	var length int
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		oldValue, expire, err := rds.getString(txn, key)
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return err
+		}
+		// 不存在的 key 写入空值时不创建
+		if len(value) == 0 {
+			length = len(oldValue)
+			return nil
+		}
+		if offset+len(value) > maxStringSize {
+			return ErrStringTooLong
+		}
+		newValue := make([]byte, max(len(oldValue), offset+len(value)))
+		copy(newValue, oldValue)
+		copy(newValue[offset:], value)
+		length = len(newValue)
+		return putWithExpire(txn, key, encodeStringValue(newValue, expire), expire)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return length, nil
+}
+
+// 读取 String 类型的值和过期时间，key 不存在或者已经过期时返回 ErrKeyNotFound
+func (rds *RedisDataStructure) getString(r reader, key []byte) ([]byte, int64, error) {
+	buf, err := rds.getAlive(r, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if buf[0] != String {
+		return nil, 0, ErrWrongTypeOperation
+	}
+	value, expire := decodeStringValue(buf)
+	return value, expire, nil
+}
+
+// String 类型的值：数据类型 + 过期时间 + 实际的值
+func encodeStringValue(value []byte, expire int64) []byte {
+	buf := make([]byte, 1+binary.MaxVarintLen64+len(value))
+	buf[0] = String
+	var index = 1
+	index += binary.PutVarint(buf[index:], expire)
+	index += copy(buf[index:], value)
+	return buf[:index]
+}
+
+func decodeStringValue(buf []byte) ([]byte, int64) {
+	var index = 1
+	expire, n := binary.Varint(buf[index:])
+	index += n
+	return buf[index:], expire
+}
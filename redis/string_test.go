@@ -0,0 +1,253 @@
+package redis
+
+import (
+	bitcask_go "bitcask-go"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func newTestRedisDataStructure(t *testing.T, name string) *RedisDataStructure {
+	opts := bitcask_go.DefaultOptions
+	dir, _ := os.MkdirTemp("", name)
+	opts.DirPath = dir
+	rds, err := NewRedisDataStructure(opts)
+	assert.Nil(t, err)
+	t.Cleanup(func() {
+		_ = rds.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return rds
+}
+
+func TestRedisDataStructure_SetNX_SetXX(t *testing.T) {
+	rds := newTestRedisDataStructure(t, "bitcask-go-redis-setnx")
+	key := utils.GetTestKey(1)
+
+	ok, err := rds.SetXX(key, 0, []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.SetNX(key, 0, []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.SetNX(key, 0, []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = rds.SetXX(key, 0, []byte("v3"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := rds.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+
+	// 其他类型的 key 也算存在
+	_, err = rds.HSet(utils.GetTestKey(2), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+	ok, err = rds.SetNX(utils.GetTestKey(2), 0, []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 过期之后不存在
+	ok, err = rds.SetNX(utils.GetTestKey(3), time.Millisecond*50, []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	time.Sleep(time.Millisecond * 100)
+	ok, err = rds.SetNX(utils.GetTestKey(3), 0, []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestRedisDataStructure_GetSet_MGet_MSet(t *testing.T) {
+	rds := newTestRedisDataStructure(t, "bitcask-go-redis-mset")
+
+	old, err := rds.GetSet(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	assert.Nil(t, old)
+	old, err = rds.GetSet(utils.GetTestKey(1), []byte("v2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), old)
+
+	err = rds.MSet(utils.GetTestKey(2), []byte("v2"), utils.GetTestKey(3), []byte("v3"))
+	assert.Nil(t, err)
+	assert.NotNil(t, rds.MSet(utils.GetTestKey(4)))
+	_, err = rds.HSet(utils.GetTestKey(5), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+
+	values, err := rds.MGet(utils.GetTestKey(1), utils.GetTestKey(2), utils.GetTestKey(4), utils.GetTestKey(5), utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("v2"), []byte("v2"), nil, nil, []byte("v3")}, values)
+
+	_, err = rds.GetSet(utils.GetTestKey(5), []byte("v"))
+	assert.Equal(t, ErrWrongTypeOperation, err)
+}
+
+func TestRedisDataStructure_IncrBy(t *testing.T) {
+	rds := newTestRedisDataStructure(t, "bitcask-go-redis-incr")
+	key := utils.GetTestKey(1)
+
+	res, err := rds.IncrBy(key, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), res)
+	res, err = rds.IncrBy(key, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), res)
+	res, err = rds.IncrBy(key, -20)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-9), res)
+	val, err := rds.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("-9"), val)
+
+	err = rds.Set(utils.GetTestKey(2), 0, []byte("abc"))
+	assert.Nil(t, err)
+	_, err = rds.IncrBy(utils.GetTestKey(2), 1)
+	assert.Equal(t, ErrNotInteger, err)
+	err = rds.Set(utils.GetTestKey(3), 0, []byte("9223372036854775807"))
+	assert.Nil(t, err)
+	_, err = rds.IncrBy(utils.GetTestKey(3), 1)
+	assert.Equal(t, ErrIntegerOverflow, err)
+
+	// 保留原来的过期时间
+	err = rds.Set(utils.GetTestKey(4), time.Minute, []byte("1"))
+	assert.Nil(t, err)
+	_, err = rds.IncrBy(utils.GetTestKey(4), 1)
+	assert.Nil(t, err)
+	ttl, err := rds.TTL(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+}
+
+func TestRedisDataStructure_Append_Range(t *testing.T) {
+	rds := newTestRedisDataStructure(t, "bitcask-go-redis-append")
+	key := utils.GetTestKey(1)
+
+	length, err := rds.Append(key, []byte("Hello"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, length)
+	length, err = rds.Append(key, []byte(" World"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, length)
+	length, err = rds.StrLen(key)
+	assert.Nil(t, err)
+	assert.Equal(t, 11, length)
+	length, err = rds.StrLen(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, length)
+
+	for _, c := range []struct {
+		start, end int
+		expected   string
+	}{
+		{0, 4, "Hello"},
+		{-5, -1, "World"},
+		{0, -1, "Hello World"},
+		{6, 100, "World"},
+		{5, 3, ""},
+		{-100, 1, "He"},
+	} {
+		val, err := rds.GetRange(key, c.start, c.end)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, string(val))
+	}
+
+	length, err = rds.SetRange(key, 6, []byte("Redis"))
+	assert.Nil(t, err)
+	assert.Equal(t, 11, length)
+	val, err := rds.Get(key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Hello Redis"), val)
+
+	// 不够长时用 0 填充
+	length, err = rds.SetRange(utils.GetTestKey(3), 3, []byte("abc"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6, length)
+	val, err = rds.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 'a', 'b', 'c'}, val)
+
+	length, err = rds.SetRange(utils.GetTestKey(4), 3, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, length)
+	_, err = rds.SetRange(key, -1, []byte("a"))
+	assert.Equal(t, ErrOffsetOutRange, err)
+	_, err = rds.SetRange(key, maxStringSize, []byte("a"))
+	assert.Equal(t, ErrStringTooLong, err)
+}
+
+func TestRedisDataStructure_Expire(t *testing.T) {
+	rds := newTestRedisDataStructure(t, "bitcask-go-redis-expire")
+
+	err := rds.Set(utils.GetTestKey(1), 0, []byte("v1"))
+	assert.Nil(t, err)
+	_, err = rds.HSet(utils.GetTestKey(2), []byte("field"), []byte("value"))
+	assert.Nil(t, err)
+
+	count, err := rds.Exists(utils.GetTestKey(1), utils.GetTestKey(2), utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	typ, err := rds.Type(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, Hash, typ)
+
+	ttl, err := rds.TTL(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(-1), ttl)
+	_, err = rds.TTL(utils.GetTestKey(3))
+	assert.Equal(t, bitcask_go.ErrKeyNotFound, err)
+
+	ok, err := rds.Expire(utils.GetTestKey(3), time.Second)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	for _, key := range [][]byte{utils.GetTestKey(1), utils.GetTestKey(2)} {
+		ok, err = rds.Expire(key, time.Millisecond*100)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		ttl, err = rds.TTL(key)
+		assert.Nil(t, err)
+		assert.True(t, ttl > 0 && ttl <= time.Millisecond*100)
+	}
+
+	ok, err = rds.SetNX(utils.GetTestKey(4), time.Millisecond*100, []byte("v4"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// 清除过期时间之后不会过期
+	ok, err = rds.Persist(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = rds.Persist(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	time.Sleep(time.Millisecond * 150)
+	val, err := rds.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	_, err = rds.Type(utils.GetTestKey(2))
+	assert.Equal(t, bitcask_go.ErrKeyNotFound, err)
+	field, err := rds.HGet(utils.GetTestKey(2), []byte("field"))
+	assert.Nil(t, err)
+	assert.Nil(t, field)
+	// 过期时间同时交给了存储引擎，merge 时可以回收
+	for _, key := range [][]byte{utils.GetTestKey(2), utils.GetTestKey(4)} {
+		_, err = rds.db.Get(key)
+		assert.Equal(t, bitcask_go.ErrKeyNotFound, err)
+	}
+
+	count, err = rds.DelKeys(utils.GetTestKey(1), utils.GetTestKey(2), utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	count, err = rds.Exists(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+
+	// ttl 小于等于 0 时直接删除
+	err = rds.Set(utils.GetTestKey(4), 0, []byte("v4"))
+	assert.Nil(t, err)
+	ok, err = rds.Expire(utils.GetTestKey(4), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, err = rds.Get(utils.GetTestKey(4))
+	assert.Equal(t, bitcask_go.ErrKeyNotFound, err)
+}
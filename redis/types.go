@@ -3,8 +3,6 @@ package redis
 import (
 	bitcask_go "bitcask-go"
 	"bitcask-go/utils"
-	"encoding/binary"
-	"errors"
 	"time"
 )
 
@@ -36,18 +34,12 @@ func (rds *RedisDataStructure) Set(key []byte, ttl time.Duration, value []byte)
 	if value == nil {
 		return nil
 	}
-	buf := make([]byte, binary.MaxVarintLen64+1)
-	buf[0] = String
-	var index = 1
 	var expire int64 = 0
 	if ttl != 0 {
-		time.Now().Add(ttl).UnixNano()
+		expire = time.Now().Add(ttl).UnixNano()
 	}
-	index += binary.PutVarint(buf[index:], expire)
-	encValue := make([]byte, index+len(value))
-	copy(encValue[:index], buf[:index])
-	copy(encValue[index:], value)
-	return rds.db.Put(key, encValue)
+	// 过期时间同时交给存储引擎处理，过期之后的数据在 merge 时可以被回收
+	return rds.db.PutWithTTL(key, encodeStringValue(value, expire), ttl)
 }
 
 func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
@@ -55,55 +47,55 @@ func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	dataType := encValue[0]
-	if dataType != String {
-		return nil, errors.New("error wrong type ")
+	if encValue[0] != String {
+		return nil, ErrWrongTypeOperation
 	}
-	var index = 1
-	expire, n := binary.Varint(encValue[index:])
-	index += n
-	if expire > 0 && expire <= time.Now().UnixNano() {
+	value, expire := decodeStringValue(encValue)
+	if isExpired(expire) {
 		return nil, nil
 	}
-	return encValue[index:], nil
+	return value, nil
 }
 
 func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
-	// 先查找元数据
-	meta, err := rds.findMetadata(key, Hash)
-	if err != nil {
-		return false, err
-	}
+	var exist bool
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		// 先查找元数据
+		meta, err := rds.findMetadata(txn, key, Hash)
+		if err != nil {
+			return err
+		}
 
-	// 构造 Hash 数据部分的 key
-	hk := &hashInternalKey{
-		key:     key,
-		version: meta.version,
-		field:   field,
-	}
-	encKey := hk.encode()
+		// 构造 Hash 数据部分的 key
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		encKey := hk.encode()
 
-	// 先查找是否存在
-	var exist = true
-	if _, err = rds.db.Get(encKey); err == bitcask_go.ErrKeyNotFound {
-		exist = false
-	}
+		// 先查找是否存在
+		if exist, err = keyExists(txn, encKey); err != nil {
+			return err
+		}
 
-	wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
-	// 不存在则更新元数据
-	if !exist {
-		meta.size++
-		_ = wb.Put(key, meta.encode())
-	}
-	_ = wb.Put(encKey, value)
-	if err = wb.Commit(); err != nil {
+		// 不存在则更新元数据
+		if !exist {
+			meta.size++
+			if err := putWithExpire(txn, key, meta.encode(), meta.expire); err != nil {
+				return err
+			}
+		}
+		return txn.Put(encKey, value)
+	})
+	if err != nil {
 		return false, err
 	}
 	return !exist, nil
 }
 
 func (rds *RedisDataStructure) HGet(key, field []byte) ([]byte, error) {
-	meta, err := rds.findMetadata(key, Hash)
+	meta, err := rds.findMetadata(rds.db, key, Hash)
 	if err != nil {
 		return nil, err
 	}
@@ -121,71 +113,78 @@ func (rds *RedisDataStructure) HGet(key, field []byte) ([]byte, error) {
 }
 
 func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, Hash)
-	if err != nil {
-		return false, err
-	}
-	if meta.size == 0 {
-		return false, nil
-	}
-
-	hk := &hashInternalKey{
-		key:     key,
-		version: meta.version,
-		field:   field,
-	}
-	encKey := hk.encode()
+	var exist bool
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		meta, err := rds.findMetadata(txn, key, Hash)
+		if err != nil {
+			return err
+		}
+		if meta.size == 0 {
+			exist = false
+			return nil
+		}
 
-	// 先查看是否存在
-	var exist = true
-	if _, err = rds.db.Get(encKey); err == bitcask_go.ErrKeyNotFound {
-		exist = false
-	}
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		encKey := hk.encode()
 
-	if exist {
-		wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		// 先查看是否存在
+		if exist, err = keyExists(txn, encKey); err != nil || !exist {
+			return err
+		}
 		meta.size--
-		_ = wb.Put(key, meta.encode())
-		_ = wb.Delete(encKey)
-		if err = wb.Commit(); err != nil {
-			return false, err
+		if err := putWithExpire(txn, key, meta.encode(), meta.expire); err != nil {
+			return err
 		}
+		return txn.Delete(encKey)
+	})
+	if err != nil {
+		return false, err
 	}
-
 	return exist, nil
 }
 
 func (rds *RedisDataStructure) SAdd(key, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, Set)
-	if err != nil {
-		return false, err
-	}
+	var ok bool
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		meta, err := rds.findMetadata(txn, key, Set)
+		if err != nil {
+			return err
+		}
 
-	sk := &setInternalKey{
-		key:     key,
-		version: meta.version,
-		member:  member,
-	}
-	encKey := sk.encode()
+		sk := &setInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
+		encKey := sk.encode()
 
-	// 先查看是否存在
-	var ok bool
-	if _, err = rds.db.Get(encKey); err == bitcask_go.ErrKeyNotFound {
-		wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
+		// 先查看是否存在
+		exist, err := keyExists(txn, encKey)
+		if err != nil {
+			return err
+		}
+		ok = !exist
+		if exist {
+			return nil
+		}
 		meta.size++
-		_ = wb.Put(key, meta.encode())
-		_ = wb.Put(encKey, nil)
-		if err = wb.Commit(); err != nil {
-			return false, err
+		if err := putWithExpire(txn, key, meta.encode(), meta.expire); err != nil {
+			return err
 		}
-		ok = true
+		return txn.Put(encKey, nil)
+	})
+	if err != nil {
+		return false, err
 	}
-
 	return ok, nil
 }
 
 func (rds *RedisDataStructure) SIsMember(key, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, Set)
+	meta, err := rds.findMetadata(rds.db, key, Set)
 	if err != nil {
 		return false, err
 	}
@@ -212,38 +211,41 @@ func (rds *RedisDataStructure) SIsMember(key, member []byte) (bool, error) {
 }
 
 func (rds *RedisDataStructure) SRem(key, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, Set)
-	if err != nil {
-		return false, err
-	}
-	if meta.size == 0 {
-		return false, nil
-	}
-
-	sk := &setInternalKey{
-		key:     key,
-		version: meta.version,
-		member:  member,
-	}
-	encKey := sk.encode()
+	var exist bool
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		meta, err := rds.findMetadata(txn, key, Set)
+		if err != nil {
+			return err
+		}
+		if meta.size == 0 {
+			exist = false
+			return nil
+		}
 
-	_, err = rds.db.Get(encKey)
-	if err != nil && err == bitcask_go.ErrKeyNotFound {
-		return false, nil
-	}
-	wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
-	meta.size--
+		sk := &setInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
+		encKey := sk.encode()
 
-	_ = wb.Put(encKey, meta.encode())
-	_ = wb.Delete(sk.encode())
-	if err = wb.Commit(); err != nil {
+		if exist, err = keyExists(txn, encKey); err != nil || !exist {
+			return err
+		}
+		meta.size--
+		if err := putWithExpire(txn, key, meta.encode(), meta.expire); err != nil {
+			return err
+		}
+		return txn.Delete(encKey)
+	})
+	if err != nil {
 		return false, err
 	}
-	return true, nil
+	return exist, nil
 }
 
-func (rds *RedisDataStructure) findMetadata(key []byte, dataType RedisDataType) (*metadata, error) {
-	metaBuf, err := rds.db.Get(key)
+func (rds *RedisDataStructure) findMetadata(r reader, key []byte, dataType RedisDataType) (*metadata, error) {
+	metaBuf, err := r.Get(key)
 	if err != nil && err != bitcask_go.ErrKeyNotFound {
 		return nil, err
 	}
@@ -256,7 +258,7 @@ func (rds *RedisDataStructure) findMetadata(key []byte, dataType RedisDataType)
 		meta = decodeMetadata(metaBuf)
 		// 判断数据类型
 		if meta.dataType != dataType {
-			return nil, errors.New("ErrWrongTypeOperation")
+			return nil, ErrWrongTypeOperation
 		}
 		// 判断过期时间
 		if meta.expire != 0 && meta.expire <= time.Now().UnixNano() {
@@ -279,6 +281,42 @@ func (rds *RedisDataStructure) findMetadata(key []byte, dataType RedisDataType)
 	return meta, nil
 }
 
+// 可以读取数据的对象，DB 和事务都实现了该接口
+type reader interface {
+	Get(key []byte) ([]byte, error)
+}
+
+// 最多重试的次数，超过之后返回 ErrTxnConflict
+const maxTxnRetries = 16
+
+// 在事务中执行读改写操作，事务冲突时重试
+func (rds *RedisDataStructure) update(fn func(txn *bitcask_go.Txn) error) error {
+	for i := 0; i < maxTxnRetries; i++ {
+		txn := rds.db.Begin()
+		if err := fn(txn); err != nil {
+			txn.Rollback()
+			return err
+		}
+		err := txn.Commit()
+		if err != bitcask_go.ErrTxnConflict {
+			return err
+		}
+	}
+	return bitcask_go.ErrTxnConflict
+}
+
+// 判断 key 是否存在
+func keyExists(r reader, key []byte) (bool, error) {
+	_, err := r.Get(key)
+	if err == bitcask_go.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (rds *RedisDataStructure) LPush(key, element []byte) (uint32, error) {
 	return rds.pushInner(key, element, true)
 }
@@ -288,34 +326,39 @@ func (rds *RedisDataStructure) RPush(key, element []byte) (uint32, error) {
 }
 
 func (rds *RedisDataStructure) pushInner(key, element []byte, isLeft bool) (uint32, error) {
-	meta, err := rds.findMetadata(key, List)
-	if err != nil {
-		return 0, err
-	}
+	var size uint32
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		meta, err := rds.findMetadata(txn, key, List)
+		if err != nil {
+			return err
+		}
 
-	lk := &listInternalKey{
-		key:     key,
-		version: meta.version,
-	}
-	if isLeft {
-		lk.index = meta.head - 1
-	} else {
-		lk.index = meta.tail
-	}
+		lk := &listInternalKey{
+			key:     key,
+			version: meta.version,
+		}
+		if isLeft {
+			lk.index = meta.head - 1
+		} else {
+			lk.index = meta.tail
+		}
 
-	wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
-	meta.size++
-	if isLeft {
-		meta.head--
-	} else {
-		meta.tail++
-	}
-	_ = wb.Put(key, meta.encode())
-	_ = wb.Put(lk.encode(), element)
-	if err = wb.Commit(); err != nil {
+		meta.size++
+		if isLeft {
+			meta.head--
+		} else {
+			meta.tail++
+		}
+		size = meta.size
+		if err := putWithExpire(txn, key, meta.encode(), meta.expire); err != nil {
+			return err
+		}
+		return txn.Put(lk.encode(), element)
+	})
+	if err != nil {
 		return 0, err
 	}
-	return meta.size, nil
+	return size, nil
 }
 
 func (rds *RedisDataStructure) LPop(key []byte) ([]byte, error) {
@@ -327,84 +370,100 @@ func (rds *RedisDataStructure) RPop(key []byte) ([]byte, error) {
 }
 
 func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error) {
-	meta, err := rds.findMetadata(key, List)
-	if err != nil {
-		return nil, err
-	}
+	var element []byte
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		meta, err := rds.findMetadata(txn, key, List)
+		if err != nil {
+			return err
+		}
+		if meta.size == 0 {
+			element = nil
+			return nil
+		}
 
-	lk := &listInternalKey{
-		key:     key,
-		version: meta.version,
-	}
-	if isLeft {
-		lk.index = meta.head
-	} else {
-		lk.index = meta.tail - 1
-	}
+		lk := &listInternalKey{
+			key:     key,
+			version: meta.version,
+		}
+		if isLeft {
+			lk.index = meta.head
+		} else {
+			lk.index = meta.tail - 1
+		}
 
-	element, err := rds.db.Get(lk.encode())
-	if err != nil {
-		return nil, err
-	}
-	meta.size--
+		if element, err = txn.Get(lk.encode()); err != nil {
+			return err
+		}
+		meta.size--
 
-	if isLeft {
-		meta.head++
-	} else {
-		meta.tail--
-	}
-	if err = rds.db.Put(key, meta.encode()); err != nil {
+		if isLeft {
+			meta.head++
+		} else {
+			meta.tail--
+		}
+		if err := putWithExpire(txn, key, meta.encode(), meta.expire); err != nil {
+			return err
+		}
+		return txn.Delete(lk.encode())
+	})
+	if err != nil {
 		return nil, err
 	}
 	return element, nil
 }
 
 func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, ZSet)
-	if err != nil {
-		return false, err
-	}
-	var exist = true
-	zk := &zsetInternalKey{
-		key:     key,
-		version: meta.version,
-		score:   score,
-		member:  member,
-	}
-	value, err := rds.db.Get(zk.encodeWithMember())
-	if err != nil && err != bitcask_go.ErrKeyNotFound {
-		return false, err
-	}
-	if err == bitcask_go.ErrKeyNotFound {
-		exist = false
-	}
-	if exist {
-		if score == utils.FloatFromBytes(value) {
-			return false, nil
+	var exist bool
+	err := rds.update(func(txn *bitcask_go.Txn) error {
+		meta, err := rds.findMetadata(txn, key, ZSet)
+		if err != nil {
+			return err
 		}
-	}
-	wb := rds.db.NewWriteBatch(bitcask_go.DefaultWriteBatchOptions)
-	if !exist {
-		meta.size++
-		_ = wb.Put(key, meta.encode())
-	} else {
-		oldKey := &zsetInternalKey{
+		zk := &zsetInternalKey{
 			key:     key,
 			version: meta.version,
-			score:   utils.FloatFromBytes(value),
+			score:   score,
+			member:  member,
 		}
-		_ = wb.Delete(oldKey.encodeWithScore())
-	}
-	_ = wb.Put(zk.encodeWithMember(), utils.Float64ToBytes(score))
-	_ = wb.Put(zk.encodeWithScore(), nil)
-	if err = wb.Commit(); err != nil {
+		value, err := txn.Get(zk.encodeWithMember())
+		if err != nil && err != bitcask_go.ErrKeyNotFound {
+			return err
+		}
+		exist = err == nil
+		if exist {
+			if score == utils.FloatFromBytes(value) {
+				return nil
+			}
+		}
+		if !exist {
+			meta.size++
+			if err := putWithExpire(txn, key, meta.encode(), meta.expire); err != nil {
+				return err
+			}
+		} else {
+			oldKey := &zsetInternalKey{
+				key:     key,
+				version: meta.version,
+				score:   utils.FloatFromBytes(value),
+				member:  member,
+			}
+			if err := txn.Delete(oldKey.encodeWithScore()); err != nil {
+				return err
+			}
+		}
+		if err := txn.Put(zk.encodeWithMember(), utils.Float64ToBytes(score)); err != nil {
+			return err
+		}
+		return txn.Put(zk.encodeWithScore(), nil)
+	})
+	if err != nil {
 		return false, err
 	}
 	return !exist, nil
 }
 
 func (rds *RedisDataStructure) ZScore(key []byte, member []byte) (float64, error) {
-	meta, err := rds.findMetadata(key, ZSet)
+	meta, err := rds.findMetadata(rds.db, key, ZSet)
 	if err != nil {
 		return -1, err
 	}
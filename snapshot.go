@@ -0,0 +1,159 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"os"
+	"sync"
+)
+
+// Snapshot 某一时刻的只读视图
+// 快照只能看到创建时已经提交的数据，并且会持有它依赖的数据文件，直到调用 Release
+type Snapshot struct {
+	db       *DB
+	index    index.Indexer
+	files    map[uint32]*data.DataFile
+	mu       *sync.RWMutex
+	released bool
+}
+
+// NewSnapshot 创建一个快照，使用完之后需要调用 Release 释放
+func (db *DB) NewSnapshot() *Snapshot {
+	// 持有锁保证批量写入的数据要么全部可见，要么全部不可见
+	db.mu.RLock()
+	files := make(map[uint32]*data.DataFile, len(db.olderFiles)+1)
+	for fid, dataFile := range db.olderFiles {
+		files[fid] = dataFile
+	}
+	if db.activeFile != nil {
+		files[db.activeFile.FileId] = db.activeFile
+	}
+	db.pinDataFiles(files)
+	// B+ 树复制全部数据的代价较大，持有锁时只开始只读事务，释放锁之后再复制
+	var indexer index.Indexer
+	var clone func() index.Indexer
+	if bpt, ok := db.index.(*index.BPlusTree); ok {
+		clone = bpt.BeginClone()
+	} else {
+		indexer = db.index.Clone()
+	}
+	db.mu.RUnlock()
+	if clone != nil {
+		indexer = clone()
+	}
+	return &Snapshot{
+		db:    db,
+		index: indexer,
+		files: files,
+		mu:    new(sync.RWMutex),
+	}
+}
+
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	return s.getValueByPosition(logRecordPos)
+}
+
+// NewIterator 在快照上创建迭代器，迭代器需要在快照释放之前关闭
+func (s *Snapshot) NewIterator(opts IteratorOptions) *Iterator {
+	lower, upper := iteratorBounds(opts)
+	return &Iterator{
+		db:        s.db,
+		snapshot:  s,
+		indexIter: s.index.RangeIterator(opts.Reverse, lower, upper),
+		option:    opts,
+	}
+}
+
+func (s *Snapshot) Fold(f func(key []byte, value []byte) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return ErrSnapshotReleased
+	}
+	iterator := s.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().IsExpired() {
+			continue
+		}
+		value, err := s.getValueByPosition(iterator.Value())
+		if err != nil {
+			return err
+		}
+		if !f(iterator.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Release 释放快照持有的数据文件，重复调用不会有影响
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	s.db.unpinDataFiles(s.files)
+	_ = s.index.Close()
+	s.index = nil
+	s.files = nil
+}
+
+func (s *Snapshot) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	return readValueFromFile(s.files[logRecordPos.Fid], logRecordPos)
+}
+
+// 增加数据文件的引用计数，需要持有 db.mu
+func (db *DB) pinDataFiles(files map[uint32]*data.DataFile) {
+	for _, dataFile := range files {
+		db.fileRefs[dataFile]++
+	}
+}
+
+// 减少数据文件的引用计数，已经废弃并且没有引用的文件会被关闭并删除
+func (db *DB) unpinDataFiles(files map[uint32]*data.DataFile) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, dataFile := range files {
+		db.fileRefs[dataFile]--
+		if db.fileRefs[dataFile] > 0 {
+			continue
+		}
+		delete(db.fileRefs, dataFile)
+		if fileName, ok := db.retiredFiles[dataFile]; ok {
+			delete(db.retiredFiles, dataFile)
+			removeDataFile(dataFile, fileName)
+		}
+	}
+}
+
+// 废弃一个不再使用的数据文件，如果仍然被快照引用，则等到引用释放之后再关闭并删除，需要持有 db.mu
+// fileName 为空表示文件已经被替换，只需要关闭
+func (db *DB) retireDataFile(dataFile *data.DataFile, fileName string) {
+	if db.fileRefs[dataFile] > 0 {
+		db.retiredFiles[dataFile] = fileName
+		return
+	}
+	removeDataFile(dataFile, fileName)
+}
+
+func removeDataFile(dataFile *data.DataFile, fileName string) {
+	_ = dataFile.Close()
+	if fileName != "" {
+		_ = os.Remove(fileName)
+	}
+}
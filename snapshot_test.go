@@ -0,0 +1,86 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_NewSnapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	defer func() {
+		_ = os.RemoveAll(db.getMergePath())
+	}()
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	snap := db.NewSnapshot()
+
+	// 快照创建之后的修改对快照不可见
+	err = db.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(200), utils.RandomValue(10))
+	_ = wb.Delete(utils.GetTestKey(3))
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	val1, err := snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val1)
+	val2, err := snap.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(2), val2)
+	_, err = snap.Get(utils.GetTestKey(200))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	var count int
+	err = snap.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, key, value)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, count)
+
+	iter := snap.NewIterator(DefaultIteratorOptions)
+	count = 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		value, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, iter.Key(), value)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 100, count)
+
+	// 数据库中是最新的数据
+	val3, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val3)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 释放之后不能再使用
+	snap.Release()
+	snap.Release()
+	_, err = snap.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrSnapshotReleased, err)
+	// 后台生成 hint 文件时也会引用数据文件
+	db.bgTasks.Wait()
+	assert.Equal(t, 0, len(db.fileRefs))
+}
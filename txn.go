@@ -0,0 +1,231 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Txn 可读写的交互式事务
+// 事务中的写入在提交之前只对当前事务可见，提交时检查读取过的 key 是否被其他写入修改过（乐观并发控制），
+// 如果有冲突则提交失败并返回 ErrTxnConflict
+type Txn struct {
+	db            *DB
+	mu            *sync.Mutex
+	pendingWrites map[string]*data.LogRecord
+	// 读取过的 key 以及读取时的位置索引，nil 表示读取时 key 不存在
+	readSet  map[string]*data.LogRecordPos
+	finished bool
+}
+
+// Begin 开启一个事务，事务结束时需要调用 Commit 或者 Rollback
+func (db *DB) Begin() *Txn {
+	return &Txn{
+		db:            db,
+		mu:            new(sync.Mutex),
+		pendingWrites: make(map[string]*data.LogRecord),
+		readSet:       make(map[string]*data.LogRecordPos),
+	}
+}
+
+// Get 读取数据，优先读取当前事务中还未提交的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return nil, ErrTxnFinished
+	}
+	if record := txn.pendingWrites[string(key)]; record != nil {
+		if record.Type == data.LogRecordDelete || record.IsExpired() {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	txn.db.mu.RLock()
+	defer txn.db.mu.RUnlock()
+	logRecordPos := txn.db.index.Get(key)
+	txn.recordRead(key, logRecordPos)
+	if logRecordPos == nil || logRecordPos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	return txn.db.getValueByPosition(logRecordPos)
+}
+
+func (txn *Txn) Put(key []byte, value []byte) error {
+	return txn.put(key, value, 0)
+}
+
+func (txn *Txn) put(key []byte, value []byte, expire int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: value, Expire: expire}
+	return nil
+}
+
+// PutWithTTL 写入一条带过期时间的数据，ttl 小于等于 0 表示永不过期
+func (txn *Txn) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+	return txn.put(key, value, expire)
+}
+
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Type: data.LogRecordDelete}
+	return nil
+}
+
+// Iterate 按照 key 的顺序遍历数据库和当前事务中的数据，f 返回 false 时停止遍历
+// KeysOnly 时传给 f 的 value 为 nil
+// 遍历到的数据库中的 key 会加入冲突检测，但是不会检测遍历之后新插入的 key
+func (txn *Txn) Iterate(opts IteratorOptions, f func(key []byte, value []byte) bool) error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	// 当前事务中写入的 key，按照遍历的顺序排序
+	lower, upper := iteratorBounds(opts)
+	var pendingKeys [][]byte
+	for _, record := range txn.pendingWrites {
+		if inBounds(record.Key, lower, upper) {
+			pendingKeys = append(pendingKeys, record.Key)
+		}
+	}
+	sort.Slice(pendingKeys, func(i, j int) bool {
+		if opts.Reverse {
+			return bytes.Compare(pendingKeys[i], pendingKeys[j]) > 0
+		}
+		return bytes.Compare(pendingKeys[i], pendingKeys[j]) < 0
+	})
+
+	// 事务中的写入会改变返回的 key，数量限制只作用在合并之后的结果上
+	limit := opts.Limit
+	opts.Limit = 0
+	iter := txn.db.NewIterator(opts)
+	defer iter.Close()
+	iter.Rewind()
+	var idx, count int
+	for iter.Valid() || idx < len(pendingKeys) {
+		if limit > 0 && count >= limit {
+			break
+		}
+		var usePending bool
+		if !iter.Valid() {
+			usePending = true
+		} else if idx < len(pendingKeys) {
+			cmp := bytes.Compare(iter.Key(), pendingKeys[idx])
+			if opts.Reverse {
+				cmp = -cmp
+			}
+			if cmp == 0 {
+				// 被当前事务覆盖的 key 以事务中的为准
+				iter.Next()
+			}
+			usePending = cmp >= 0
+		}
+
+		var key, value []byte
+		if usePending {
+			record := txn.pendingWrites[string(pendingKeys[idx])]
+			idx++
+			if record.Type == data.LogRecordDelete || record.IsExpired() {
+				continue
+			}
+			key, value = record.Key, record.Value
+			if opts.KeysOnly {
+				value = nil
+			}
+		} else if opts.KeysOnly {
+			key = iter.Key()
+			txn.recordRead(key, iter.indexIter.Value())
+			iter.Next()
+		} else {
+			key = iter.Key()
+			txn.recordRead(key, iter.indexIter.Value())
+			val, err := iter.Value()
+			iter.Next()
+			if err == ErrKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			value = val
+		}
+		count++
+		if !f(key, value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Commit 提交事务，如果读取过的 key 在事务开始之后被修改过，返回 ErrTxnConflict
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.finished = true
+
+	// 冲突检测和写入在组提交的 leader 中一起完成，同一组中排在前面的请求写入的 key 也算冲突
+	return txn.db.commitRecords(txn.pendingWrites, txn.db.options.SyncWrite,
+		func(written map[string]struct{}) error {
+			for key, readPos := range txn.readSet {
+				if _, ok := written[key]; ok {
+					return ErrTxnConflict
+				}
+				if !isSamePos(readPos, txn.db.index.Get([]byte(key))) {
+					return ErrTxnConflict
+				}
+			}
+			return nil
+		})
+}
+
+// Rollback 放弃事务中所有的写入
+func (txn *Txn) Rollback() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	txn.finished = true
+	txn.pendingWrites = nil
+	txn.readSet = nil
+}
+
+// 记录第一次读取到的位置，提交时用于冲突检测
+func (txn *Txn) recordRead(key []byte, pos *data.LogRecordPos) {
+	if _, ok := txn.readSet[string(key)]; !ok {
+		txn.readSet[string(key)] = pos
+	}
+}
+
+func isSamePos(a, b *data.LogRecordPos) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Fid == b.Fid && a.Offset == b.Offset
+}
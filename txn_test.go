@@ -0,0 +1,115 @@
+package bitcask_go
+
+import (
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_Txn(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 事务中可以读到自己的写入，提交之前其他人不可见
+	txn := db.Begin()
+	err = txn.Put(utils.GetTestKey(1), []byte("txn value"))
+	assert.Nil(t, err)
+	err = txn.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = txn.Put(utils.GetTestKey(20), []byte("txn value"))
+	assert.Nil(t, err)
+	val, err := txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("txn value"), val)
+	_, err = txn.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+
+	var keys [][]byte
+	err = txn.Iterate(DefaultIteratorOptions, func(key []byte, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(keys))
+	assert.Contains(t, keys, utils.GetTestKey(20))
+	assert.NotContains(t, keys, utils.GetTestKey(2))
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("txn value"), val)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = txn.Put(utils.GetTestKey(3), nil)
+	assert.Equal(t, ErrTxnFinished, err)
+
+	// 事务中写入的过期时间在提交之后生效
+	txn = db.Begin()
+	err = txn.PutWithTTL(utils.GetTestKey(3), []byte("ttl value"), time.Millisecond*100)
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ttl value"), val)
+	time.Sleep(time.Millisecond * 150)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 读取过的 key 被修改之后提交失败
+	txn1 := db.Begin()
+	_, err = txn1.Get(utils.GetTestKey(5))
+	assert.Nil(t, err)
+	_ = txn1.Put(utils.GetTestKey(6), []byte("txn1"))
+	err = db.Put(utils.GetTestKey(5), []byte("changed"))
+	assert.Nil(t, err)
+	err = txn1.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	val, err = db.Get(utils.GetTestKey(6))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(6), val)
+
+	// 读取时不存在的 key 被插入也是冲突
+	txn2 := db.Begin()
+	_, err = txn2.Get(utils.GetTestKey(30))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_ = txn2.Put(utils.GetTestKey(30), []byte("txn2"))
+	err = db.Put(utils.GetTestKey(30), []byte("other"))
+	assert.Nil(t, err)
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 回滚之后写入不生效
+	txn3 := db.Begin()
+	_ = txn3.Put(utils.GetTestKey(40), []byte("txn3"))
+	txn3.Rollback()
+	_, err = db.Get(utils.GetTestKey(40))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启之后事务写入的数据仍然存在
+	err = db.Close()
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("txn value"), val)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
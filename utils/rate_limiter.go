@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter 令牌桶限速器，用于限制后台任务每秒读写的字节数
+// nil 的 RateLimiter 表示不限速
+type RateLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond float64
+	available      float64 // 当前可用的字节数，最多积累一秒的量
+	last           time.Time
+}
+
+// NewRateLimiter 创建限速器，bytesPerSecond 小于等于 0 时返回 nil，即不限速
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		bytesPerSecond: float64(bytesPerSecond),
+		available:      float64(bytesPerSecond),
+		last:           time.Now(),
+	}
+}
+
+// Wait 消耗 n 个字节的额度，额度不够时阻塞等待
+func (rl *RateLimiter) Wait(n int64) {
+	if wait := rl.reserve(n); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// WaitContext 和 Wait 相同，等待的过程中 ctx 被取消时返回 ctx 的错误
+func (rl *RateLimiter) WaitContext(ctx context.Context, n int64) error {
+	wait := rl.reserve(n)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// 消耗 n 个字节的额度，返回需要等待的时间
+func (rl *RateLimiter) reserve(n int64) time.Duration {
+	if rl == nil || n <= 0 {
+		return 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	rl.available += now.Sub(rl.last).Seconds() * rl.bytesPerSecond
+	if rl.available > rl.bytesPerSecond {
+		rl.available = rl.bytesPerSecond
+	}
+	rl.last = now
+	rl.available -= float64(n)
+	if rl.available < 0 {
+		return time.Duration(-rl.available / rl.bytesPerSecond * float64(time.Second))
+	}
+	return 0
+}
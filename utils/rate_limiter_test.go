@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	// 不限速
+	var rl *RateLimiter
+	rl.Wait(1024)
+	assert.Nil(t, NewRateLimiter(0))
+
+	rl = NewRateLimiter(1000)
+	now := time.Now()
+	// 第一秒的额度可以直接使用
+	rl.Wait(1000)
+	assert.True(t, time.Since(now) < time.Millisecond*100)
+	// 额度用完之后需要等待
+	rl.Wait(200)
+	assert.True(t, time.Since(now) >= time.Millisecond*150)
+}
+
+func TestRateLimiter_WaitContext(t *testing.T) {
+	var rl *RateLimiter
+	assert.Nil(t, rl.WaitContext(context.Background(), 1024))
+
+	rl = NewRateLimiter(1000)
+	assert.Nil(t, rl.WaitContext(context.Background(), 1000))
+	// 等待的过程中被取消
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	now := time.Now()
+	err := rl.WaitContext(ctx, 1000)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(now) < time.Millisecond*500)
+}
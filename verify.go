@@ -0,0 +1,527 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gofrs/flock"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VerifyReport 数据目录的检查结果
+type VerifyReport struct {
+	DataFileNum int             // 检查过的数据文件数量
+	RecordNum   int             // 可以正确读取的记录数量
+	Problems    []VerifyProblem // 发现的问题，为空表示数据目录完好
+}
+
+// VerifyProblem 检查中发现的一个问题
+type VerifyProblem struct {
+	File   string // 出现问题的文件或目录
+	Offset int64  // 出现问题的位置，-1 表示和具体位置无关
+	Reason string
+}
+
+func (p VerifyProblem) String() string {
+	if p.Offset < 0 {
+		return fmt.Sprintf("%s: %s", p.File, p.Reason)
+	}
+	return fmt.Sprintf("%s at offset %d: %s", p.File, p.Offset, p.Reason)
+}
+
+// OK 数据目录是否没有任何问题
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) addProblem(file string, offset int64, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, VerifyProblem{
+		File:   file,
+		Offset: offset,
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+// Verify 离线检查数据目录，校验数据文件、hint 索引文件、索引检查点文件、事务序列号文件和 merge 完成标识文件，
+// 并检查是否有未处理的 merge 目录，数据库不能处于打开状态
+func Verify(dir string) (*VerifyReport, error) {
+	return VerifyWithKeyProvider(dir, nil)
+}
+
+// VerifyWithKeyProvider 检查加密的数据目录
+func VerifyWithKeyProvider(dir string, provider KeyProvider) (*VerifyReport, error) {
+	fileLock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+
+	report := &VerifyReport{}
+	var dataCipher *data.Cipher
+	if provider != nil {
+		dataCipher = data.NewCipher(provider)
+	}
+	dataFiles, err := openDataFilesForVerify(dir, dataCipher)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDataFiles(dataFiles)
+
+	for _, dataFile := range dataFiles {
+		err := verifyDataFile(report, dataFile, filepath.Base(data.GetDataFileName(dir, dataFile.FileId)), nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	nonMergeFileId, hasMerge, err := verifyMergeFinishedFile(report, dir, dataFiles)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyHintFile(report, dir, dataFiles, hasMerge, nonMergeFileId, dataCipher); err != nil {
+		return nil, err
+	}
+	for _, dataFile := range dataFiles {
+		if err := verifyDataHintFile(report, dir, dataFile, dataCipher); err != nil {
+			return nil, err
+		}
+	}
+	if err := verifyIndexCheckpointFile(report, dir, dataFiles, dataCipher); err != nil {
+		return nil, err
+	}
+	if err := verifySeqNoFile(report, dir, dataCipher); err != nil {
+		return nil, err
+	}
+	verifyMergeDir(report, dir)
+	return report, nil
+}
+
+// Repair 将 dir 中可以读取的数据重新写到一个新的目录 destDir 中，dir 中的文件不会被修改
+// 损坏的数据会被丢弃，hint 索引文件会在之后 merge 的时候重新生成，未完成的 merge 目录会被忽略。
+// 新目录中没有索引文件，使用 B+ 树索引打开时会从数据文件中重新建立索引
+func Repair(dir, destDir string) (*VerifyReport, error) {
+	return RepairWithKeyProvider(dir, destDir, nil)
+}
+
+// RepairWithKeyProvider 修复加密的数据目录，新目录中的数据使用 provider 当前的密钥加密
+func RepairWithKeyProvider(dir, destDir string, provider KeyProvider) (*VerifyReport, error) {
+	fileLock, err := lockDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+
+	if entries, err := os.ReadDir(destDir); err == nil && len(entries) > 0 {
+		return nil, errors.New("repair destination directory is not empty")
+	}
+	if err := os.MkdirAll(destDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{}
+	var dataCipher *data.Cipher
+	if provider != nil {
+		dataCipher = data.NewCipher(provider)
+	}
+	dataFiles, err := openDataFilesForVerify(dir, dataCipher)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDataFiles(dataFiles)
+
+	for _, dataFile := range dataFiles {
+		destFile, err := data.OpenDataFile(destDir, dataFile.FileId, fio.StandardFIO)
+		if err != nil {
+			return nil, err
+		}
+		err = verifyDataFile(report, dataFile, filepath.Base(data.GetDataFileName(dir, dataFile.FileId)),
+			func(logRecord *data.LogRecord) error {
+				encRecord, _, err := data.EncodeLogRecordWithCipher(logRecord, dataCipher)
+				if err != nil {
+					return err
+				}
+				return destFile.Write(encRecord)
+			})
+		if err == nil {
+			err = destFile.Sync()
+		}
+		_ = destFile.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 事务序列号文件完好时才复制
+	problemNum := len(report.Problems)
+	if err := verifySeqNoFile(report, dir, dataCipher); err != nil {
+		return nil, err
+	}
+	seqNoFileName := filepath.Join(dir, data.SeqNoFileName)
+	if _, err := os.Stat(seqNoFileName); err == nil && len(report.Problems) == problemNum {
+		buf, err := os.ReadFile(seqNoFileName)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(destDir, data.SeqNoFileName), buf, fio.DataFilePerm); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func lockDir(dir string) (*flock.Flock, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	fileLock := flock.New(filepath.Join(dir, fileLockName))
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrDatabaseIsUsing
+	}
+	return fileLock, nil
+}
+
+// 按照文件 id 从小到大打开目录中所有的数据文件
+func openDataFilesForVerify(dir string, dataCipher *data.Cipher) ([]*data.DataFile, error) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var fileIds []int
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid data file name %s", entry.Name())
+		}
+		fileIds = append(fileIds, fileId)
+	}
+	sort.Ints(fileIds)
+	var dataFiles []*data.DataFile
+	for _, fid := range fileIds {
+		dataFile, err := data.OpenDataFile(dir, uint32(fid), fio.StandardFIO)
+		if err != nil {
+			closeDataFiles(dataFiles)
+			return nil, err
+		}
+		dataFile.Cipher = dataCipher
+		dataFiles = append(dataFiles, dataFile)
+	}
+	return dataFiles, nil
+}
+
+func closeDataFiles(dataFiles []*data.DataFile) {
+	for _, dataFile := range dataFiles {
+		_ = dataFile.Close()
+	}
+}
+
+// 检查数据文件中的每一条记录，损坏的数据会被跳过并记录到 report 中，fn 不为空时对每条有效的记录调用
+func verifyDataFile(report *VerifyReport, dataFile *data.DataFile, name string, fn func(*data.LogRecord) error) error {
+	report.DataFileNum++
+	var offset int64 = 0
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			return nil
+		}
+		if err == data.ErrInvalidCRC || err == io.ErrUnexpectedEOF {
+			reason := "invalid crc"
+			if err == io.ErrUnexpectedEOF {
+				reason = "incomplete record or invalid header"
+			}
+			nextOffset, err := dataFile.FindNextRecord(offset + 1)
+			if err == io.EOF {
+				report.addProblem(name, offset, "%s, no valid record until the end of file", reason)
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			report.addProblem(name, offset, "%s, %d bytes skipped", reason, nextOffset-offset)
+			offset = nextOffset
+			continue
+		}
+		if err == data.ErrDecryptFailed || err == data.ErrNoKeyProvider {
+			report.addProblem(name, offset, "%v", err)
+			offset += size
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		report.RecordNum++
+		if fn != nil {
+			if err := fn(logRecord); err != nil {
+				return err
+			}
+		}
+		offset += size
+	}
+}
+
+// 检查 merge 完成标识文件，返回没有参与 merge 的最小文件 id
+func verifyMergeFinishedFile(report *VerifyReport, dir string, dataFiles []*data.DataFile) (uint32, bool, error) {
+	fileName := filepath.Join(dir, data.MergeFinishedFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dir)
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	if err != nil {
+		report.addProblem(data.MergeFinishedFileName, 0, "read record failed: %v", err)
+		return 0, false, nil
+	}
+	if string(record.Key) != mergeFinishedKey {
+		report.addProblem(data.MergeFinishedFileName, 0, "unexpected key %q", record.Key)
+		return 0, false, nil
+	}
+	nonMergeFileId, err := strconv.ParseUint(string(record.Value), 10, 32)
+	if err != nil {
+		report.addProblem(data.MergeFinishedFileName, 0, "invalid non-merge file id %q", record.Value)
+		return 0, false, nil
+	}
+	if len(dataFiles) > 0 && uint32(nonMergeFileId) > dataFiles[len(dataFiles)-1].FileId+1 {
+		report.addProblem(data.MergeFinishedFileName, 0, "non-merge file id %d is larger than the last data file", nonMergeFileId)
+	}
+	return uint32(nonMergeFileId), true, nil
+}
+
+// 检查 hint 索引文件中的位置是否指向数据文件中真实存在的记录
+func verifyHintFile(report *VerifyReport, dir string, dataFiles []*data.DataFile, hasMerge bool,
+	nonMergeFileId uint32, dataCipher *data.Cipher) error {
+	fileName := filepath.Join(dir, data.HintFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	if !hasMerge {
+		report.addProblem(data.HintFileName, -1, "hint file exists without merge finished file")
+	}
+	files := make(map[uint32]*data.DataFile, len(dataFiles))
+	for _, dataFile := range dataFiles {
+		files[dataFile.FileId] = dataFile
+	}
+
+	hintFile, err := data.OpenHintFile(dir)
+	if err != nil {
+		return err
+	}
+	hintFile.Cipher = dataCipher
+	defer func() {
+		_ = hintFile.Close()
+	}()
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			report.addProblem(data.HintFileName, offset, "read record failed: %v", err)
+			return nil
+		}
+		// 结尾记录中是 merge 之后每个数据文件的统计
+		if logRecord.Type == data.LogRecordHintFooter {
+			offset += size
+			continue
+		}
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if reason := checkHintPos(logRecord.Key, pos, files, hasMerge, nonMergeFileId); reason != "" {
+			report.addProblem(data.HintFileName, offset, "key %q: %s", logRecord.Key, reason)
+		}
+		offset += size
+	}
+}
+
+func checkHintPos(key []byte, pos *data.LogRecordPos, files map[uint32]*data.DataFile,
+	hasMerge bool, nonMergeFileId uint32) string {
+	if hasMerge && pos.Fid >= nonMergeFileId {
+		return fmt.Sprintf("points to data file %d which is not merged", pos.Fid)
+	}
+	dataFile := files[pos.Fid]
+	if dataFile == nil {
+		return fmt.Sprintf("data file %d not found", pos.Fid)
+	}
+	logRecord, size, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		return fmt.Sprintf("no valid record in data file %d at offset %d: %v", pos.Fid, pos.Offset, err)
+	}
+	if realKey, _ := parseLogRecordKey(logRecord.Key); !bytes.Equal(realKey, key) {
+		return fmt.Sprintf("record in data file %d at offset %d has a different key", pos.Fid, pos.Offset)
+	}
+	if pos.Size != 0 && int64(pos.Size) != size {
+		return fmt.Sprintf("record size %d does not match %d", size, pos.Size)
+	}
+	return ""
+}
+
+// 检查数据文件对应的 hint 文件中的记录是否和数据文件中的记录一致
+func verifyDataHintFile(report *VerifyReport, dir string, dataFile *data.DataFile, dataCipher *data.Cipher) error {
+	fileName := data.GetDataHintFileName(dir, dataFile.FileId)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	name := filepath.Base(fileName)
+	hintFile, err := data.OpenDataHintFile(dir, dataFile.FileId)
+	if err != nil {
+		return err
+	}
+	hintFile.Cipher = dataCipher
+	defer func() {
+		_ = hintFile.Close()
+	}()
+	var offset int64 = 0
+	for {
+		hintRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			report.addProblem(name, offset, "read record failed: %v", err)
+			return nil
+		}
+		if hintRecord.Type == data.LogRecordHintFooter {
+			_, ok, err := data.CheckDataHintFooter(hintRecord, dataFile)
+			if err != nil {
+				report.addProblem(name, offset, "%v", err)
+			} else if !ok {
+				report.addProblem(name, offset, "footer does not match the data file")
+			}
+			return nil
+		}
+		hintLogRecord, recordOffset, recordSize, err := data.ParseDataHintRecord(hintRecord)
+		if err != nil {
+			report.addProblem(name, offset, "%v", err)
+			return nil
+		}
+		logRecord, actualSize, err := dataFile.ReadLogRecord(recordOffset)
+		if err != nil {
+			report.addProblem(name, offset, "no valid record in data file at offset %d: %v", recordOffset, err)
+		} else if !bytes.Equal(logRecord.Key, hintLogRecord.Key) || logRecord.Type != hintLogRecord.Type ||
+			actualSize != recordSize {
+			report.addProblem(name, offset, "record in data file at offset %d does not match", recordOffset)
+		}
+		offset += size
+	}
+}
+
+// 检查索引检查点文件是否完整，并且其中的位置都指向数据文件中真实存在的记录
+func verifyIndexCheckpointFile(report *VerifyReport, dir string, dataFiles []*data.DataFile, dataCipher *data.Cipher) error {
+	fileName := filepath.Join(dir, data.IndexCheckpointFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	files := make(map[uint32]*data.DataFile, len(dataFiles))
+	for _, dataFile := range dataFiles {
+		files[dataFile.FileId] = dataFile
+	}
+
+	cpFile, err := data.OpenIndexCheckpointFile(dir)
+	if err != nil {
+		return err
+	}
+	cpFile.Cipher = dataCipher
+	defer func() {
+		_ = cpFile.Close()
+	}()
+	var keyNum int64
+	var offset int64 = 0
+	for {
+		logRecord, size, err := cpFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			report.addProblem(data.IndexCheckpointFileName, offset, "missing checkpoint finished record")
+			return nil
+		}
+		if err != nil {
+			report.addProblem(data.IndexCheckpointFileName, offset, "read record failed: %v", err)
+			return nil
+		}
+		if logRecord.Type == data.LogRecordTxnFinished && string(logRecord.Key) == indexCheckpointFinKey {
+			cp, err := decodeIndexCheckpoint(logRecord.Value)
+			if err != nil {
+				report.addProblem(data.IndexCheckpointFileName, offset, "%v", err)
+			} else if cp.keyNum != keyNum {
+				report.addProblem(data.IndexCheckpointFileName, offset, "key num %d does not match %d", keyNum, cp.keyNum)
+			} else if dataFile := files[cp.fid]; dataFile == nil {
+				report.addProblem(data.IndexCheckpointFileName, offset, "data file %d not found", cp.fid)
+			} else if fileSize, err := dataFile.IoManager.Size(); err != nil {
+				return err
+			} else if fileSize < cp.offset {
+				report.addProblem(data.IndexCheckpointFileName, offset,
+					"data file %d is shorter than offset %d", cp.fid, cp.offset)
+			}
+			return nil
+		}
+		// 结尾记录中是 merge 之后每个数据文件的统计
+		if logRecord.Type == data.LogRecordHintFooter {
+			offset += size
+			continue
+		}
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if reason := checkHintPos(logRecord.Key, pos, files, false, 0); reason != "" {
+			report.addProblem(data.IndexCheckpointFileName, offset, "key %q: %s", logRecord.Key, reason)
+		}
+		keyNum++
+		offset += size
+	}
+}
+
+func verifySeqNoFile(report *VerifyReport, dir string, dataCipher *data.Cipher) error {
+	fileName := filepath.Join(dir, data.SeqNoFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	seqNoFile, err := data.OpenSeqNoFile(dir)
+	if err != nil {
+		return err
+	}
+	seqNoFile.Cipher = dataCipher
+	defer func() {
+		_ = seqNoFile.Close()
+	}()
+	record, _, err := seqNoFile.ReadLogRecord(0)
+	if err != nil {
+		report.addProblem(data.SeqNoFileName, 0, "read record failed: %v", err)
+		return nil
+	}
+	if string(record.Key) != seqNoKey {
+		report.addProblem(data.SeqNoFileName, 0, "unexpected key %q", record.Key)
+	} else if _, err := strconv.ParseUint(string(record.Value), 10, 64); err != nil {
+		report.addProblem(data.SeqNoFileName, 0, "invalid seq no %q", record.Value)
+	}
+	return nil
+}
+
+// 检查是否有 merge 目录，完成的 merge 会在下次打开时生效，未完成的会被丢弃
+func verifyMergeDir(report *VerifyReport, dir string) {
+	mergePath := filepath.Join(filepath.Dir(filepath.Clean(dir)), filepath.Base(dir)+mergeDirName)
+	if _, err := os.Stat(mergePath); err != nil {
+		return
+	}
+	if _, err := os.Stat(filepath.Join(mergePath, data.MergeFinishedFileName)); err == nil {
+		report.addProblem(mergePath, -1, "finished merge directory not applied yet, it will be applied on next open")
+	} else {
+		report.addProblem(mergePath, -1, "orphaned merge directory of an unfinished merge, it will be removed on next open")
+	}
+}
@@ -0,0 +1,142 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer func() {
+		destroyDB(db)
+	}()
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(20))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 数据库打开时不能检查
+	_, err = Verify(dir)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// merge 的结果还没有生效
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Problems))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.Problems)
+	assert.True(t, report.DataFileNum > 1)
+
+	// 破坏一条记录
+	f, _ := os.OpenFile(data.GetDataFileName(dir, 0), os.O_WRONLY, 0644)
+	_, _ = f.WriteAt([]byte{0xff, 0xff}, 100)
+	_ = f.Close()
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+
+	// 修复到新的目录之后可以正常打开
+	destDir, _ := os.MkdirTemp("", "bitcask-go-repair")
+	defer func() {
+		_ = os.RemoveAll(destDir)
+	}()
+	report, err = Repair(dir, destDir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	report, err = Verify(destDir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.Problems)
+
+	repairOpts := opts
+	repairOpts.DirPath = destDir
+	db2, err := Open(repairOpts)
+	assert.Nil(t, err)
+	assert.True(t, len(db2.ListKeys()) > 800)
+	_, err = db2.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	_ = db2.Close()
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+}
+
+func TestRepair_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-repair-bptree")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.IndexType = BPlusTree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(20))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 破坏一条记录
+	f, _ := os.OpenFile(data.GetDataFileName(dir, 0), os.O_WRONLY, 0644)
+	_, _ = f.WriteAt([]byte{0xff, 0xff}, 100)
+	_ = f.Close()
+
+	// 修复之后的目录没有 B+ 树索引文件，打开时从数据文件中重新建立索引
+	destDir, _ := os.MkdirTemp("", "bitcask-go-repair-bptree-dest")
+	defer func() {
+		_ = os.RemoveAll(destDir)
+	}()
+	report, err := Repair(dir, destDir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+
+	repairOpts := opts
+	repairOpts.DirPath = destDir
+	db2, err := Open(repairOpts)
+	assert.Nil(t, err)
+	keys := db2.ListKeys()
+	assert.True(t, len(keys) > 800 && len(keys) <= 900, len(keys))
+	_, err = db2.Get(utils.GetTestKey(50))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.Nil(t, db2.Close())
+
+	// 再次打开时使用保存的索引
+	db2, err = Open(repairOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, len(keys), len(db2.ListKeys()))
+	assert.Nil(t, db2.Close())
+}